@@ -6,6 +6,7 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.0
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/prometheus/client_golang v1.10.0
 	github.com/rs/zerolog v1.22.0
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
@@ -12,8 +12,86 @@ create table links
     real_link   varchar(255),
     key         varchar(255) unique,
     use_counter int default 0,
+    created_at  timestamptz not null default now(),
+    -- start of a scheduled link, null means it works since creation
+    active_from timestamptz default null,
+    expires_at  timestamptz default null,
+    -- link.NormalizeDestination of real_link, links to the same destination are reused
+    normalized_link text,
+    -- bcrypt hash of the password which unlocks the link
+    password_hash   varchar(60) default null,
+    -- redirects after which the link stops working, null means unlimited
+    max_clicks      bigint default null,
+    -- paused links don't redirect until their owner resumes them
+    paused          boolean not null default false,
+    -- links in the trash, purged ones keep only the key so it is never reused
+    deleted_at      timestamptz default null,
+    purged_at       timestamptz default null,
 
     constraint fk_creator
         foreign key (creator_id)
             references accounts (id)
-);
\ No newline at end of file
+);
+
+create index links_creator_destination on links (creator_id, normalized_link);
+create index links_trash on links (deleted_at) where deleted_at is not null and purged_at is null;
+
+-- previous destinations of links
+create table link_history
+(
+    id          bigserial primary key,
+    link_id     int          not null,
+    real_link   varchar(255) not null,
+    replaced_at timestamptz  not null,
+
+    constraint fk_link
+        foreign key (link_id)
+            references links (id)
+            on delete cascade
+);
+
+create index link_history_link on link_history (link_id);
+
+-- blocks of ids of generated keys, the increment is link.KeyIdBlockSize
+create sequence link_key_ids increment by 1000;
+
+create table link_settings
+(
+    account_id int primary key,
+    max_ttl    bigint default null, -- seconds
+    alphabet   varchar(32) default null,
+    key_length int default null,
+
+    constraint fk_account
+        foreign key (account_id)
+            references accounts (id)
+);
+
+create table link_clicks
+(
+    id              bigserial primary key,
+    link_key        varchar(255) not null,
+    clicked_at      timestamptz  not null,
+    referrer        text,
+    user_agent      text,
+    ip              varchar(64), -- anonymized
+    browser         varchar(64),
+    os              varchar(64),
+    device          varchar(16),
+    referrer_domain varchar(255),
+    country         char(2),
+    region          varchar(255),
+    city            varchar(255),
+    is_bot          boolean      not null default false
+);
+
+create index link_clicks_key_time on link_clicks (link_key, clicked_at);
+
+create table link_visitor_sketches
+(
+    link_key varchar(255) not null,
+    hour     timestamptz  not null,
+    sketch   bytea        not null, -- HyperLogLog of unique visitors
+
+    primary key (link_key, hour)
+);
@@ -0,0 +1,73 @@
+// Package bloom implements Bloom filters: sets which answer whether an item
+// may be in the set with no false negatives and a tunable rate of false positives.
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+type Filter struct {
+	bits  []uint64
+	m     uint64
+	k     uint64
+	items uint64
+}
+
+// New makes a filter for n items with the false positive rate p when it is full.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+func (f *Filter) Add(item string) {
+	h1, h2 := hashes(item)
+	for i := uint64(0); i < f.k; i++ {
+		idx := (h1 + i*h2) % f.m
+		f.bits[idx/64] |= 1 << (idx % 64)
+	}
+	f.items++
+}
+
+// MayContain is false only for items which have never been added.
+func (f *Filter) MayContain(item string) bool {
+	h1, h2 := hashes(item)
+	for i := uint64(0); i < f.k; i++ {
+		idx := (h1 + i*h2) % f.m
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FalsePositiveRate estimates the probability of MayContain being true for an
+// item which has not been added, given the number of added items.
+func (f *Filter) FalsePositiveRate() float64 {
+	return math.Pow(1-math.Exp(-float64(f.k)*float64(f.items)/float64(f.m)), float64(f.k))
+}
+
+// hashes derives the k hashes of an item from two halves of one 128-bit hash
+// (Kirsch and Mitzenmacher double hashing).
+func hashes(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[8+i])
+	}
+	// an odd step visits different bits for every i
+	return h1, h2 | 1
+}
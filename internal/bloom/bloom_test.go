@@ -0,0 +1,32 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Filter(t *testing.T) {
+	const n = 10000
+	const p = 0.01
+	f := New(n, p)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("key%d", i))
+	}
+	for i := 0; i < n; i++ {
+		if !f.MayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("Added item key%d MUST be found", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.MayContain(fmt.Sprintf("other%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 2*p {
+		t.Errorf("False positive rate MUST be about %v, but %v given", p, rate)
+	}
+	if rate := f.FalsePositiveRate(); rate < p/2 || rate > 2*p {
+		t.Errorf("Estimated false positive rate MUST be about %v, but %v given", p, rate)
+	}
+}
@@ -0,0 +1,165 @@
+package link
+
+import (
+	"koro.che/internal/hll"
+	"time"
+)
+
+// Click is a single redirect through a short link.
+type Click struct {
+	Key       string
+	At        time.Time
+	Referrer  string
+	UserAgent string
+	// IP is the anonymized address of the client.
+	IP string
+
+	Browser string
+	OS      string
+	Device  string
+	// ReferrerDomain is the host of Referrer without "www.", empty for direct visits.
+	ReferrerDomain string
+	GeoLocation
+	// VisitorHash identifies the client for counting unique visitors, it is not stored.
+	VisitorHash uint64
+	// IsBot marks clicks of crawlers, link preview fetchers and prefetching.
+	IsBot bool
+}
+
+// Bot filters of click stats.
+const (
+	BotsExclude = "exclude"
+	BotsInclude = "include"
+	BotsOnly    = "only"
+)
+
+// Matches reports whether the click passes the bot filter.
+func (c Click) Matches(bots string) bool {
+	switch bots {
+	case BotsInclude:
+		return true
+	case BotsOnly:
+		return c.IsBot
+	default:
+		return !c.IsBot
+	}
+}
+
+// VisitorSketch counts unique visitors of the link during the hour starting at Hour.
+type VisitorSketch struct {
+	Key    string
+	Hour   time.Time
+	Sketch *hll.Sketch
+}
+
+// GeoLocation is where the client is, fields are empty when unknown.
+type GeoLocation struct {
+	// Country is ISO 3166-1 alpha-2 code.
+	Country string
+	Region  string
+	City    string
+}
+
+// Dimensions of click breakdowns.
+const (
+	DimensionBrowser  = "browser"
+	DimensionOS       = "os"
+	DimensionDevice   = "device"
+	DimensionReferrer = "referrer"
+	DimensionCountry  = "country"
+	DimensionRegion   = "region"
+	DimensionCity     = "city"
+)
+
+// BreakdownQuery asks for the Limit most frequent values of the Dimension
+// among clicks of the link in [From, To).
+type BreakdownQuery struct {
+	Key       string
+	Dimension string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Bots      string
+}
+
+type ValueCount struct {
+	Value  string
+	Clicks uint64
+}
+
+// Value returns the click property the breakdown groups by.
+func (c Click) Value(dimension string) string {
+	switch dimension {
+	case DimensionBrowser:
+		return c.Browser
+	case DimensionOS:
+		return c.OS
+	case DimensionDevice:
+		return c.Device
+	case DimensionReferrer:
+		return c.ReferrerDomain
+	case DimensionCountry:
+		return c.Country
+	case DimensionRegion:
+		return c.Region
+	case DimensionCity:
+		return c.City
+	}
+	return ""
+}
+
+const (
+	IntervalHour = "hour"
+	IntervalDay  = "day"
+	IntervalWeek = "week"
+)
+
+// ClickQuery selects clicks of the link in [From, To) grouped into buckets
+// of Interval length in Location.
+type ClickQuery struct {
+	Key      string
+	From     time.Time
+	To       time.Time
+	Interval string
+	Location *time.Location
+	Bots     string
+}
+
+type ClickBucket struct {
+	Start    time.Time
+	Clicks   uint64
+	Visitors uint64
+}
+
+// TruncateTime returns the start of the bucket containing t. Like date_trunc
+// in Postgres, weeks start on Monday.
+func TruncateTime(t time.Time, interval string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch interval {
+	case IntervalHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case IntervalWeek:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// NextBucket returns the start of the bucket following the one starting at start.
+func NextBucket(start time.Time, interval string, loc *time.Location) time.Time {
+	var next time.Time
+	switch interval {
+	case IntervalHour:
+		next = TruncateTime(start.Add(time.Hour), interval, loc)
+		if !next.After(start) {
+			// the hour repeats when clocks go back
+			next = start.Add(time.Hour)
+		}
+	case IntervalWeek:
+		next = TruncateTime(start.AddDate(0, 0, 7), interval, loc)
+	default:
+		next = TruncateTime(start.AddDate(0, 0, 1), interval, loc)
+	}
+	return next
+}
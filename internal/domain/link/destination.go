@@ -0,0 +1,30 @@
+package link
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeDestination makes the same forms of a destination equal: links
+// without a scheme are followed by https, scheme and host are case
+// insensitive, default ports and an empty path may be omitted.
+func NormalizeDestination(destination string) string {
+	destination = strings.TrimSpace(destination)
+	if !strings.Contains(destination, "://") {
+		destination = "https://" + destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	switch {
+	case u.Scheme == "https" && u.Port() == "443", u.Scheme == "http" && u.Port() == "80":
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
@@ -0,0 +1,15 @@
+package link
+
+import "testing"
+
+func Test_NormalizeDestination(t *testing.T) {
+	same := []string{"example.com", "https://example.com/", "HTTPS://Example.COM:443", " example.com "}
+	for _, d := range same {
+		if actual := NormalizeDestination(d); actual != "https://example.com/" {
+			t.Errorf("Destination %q MUST be normalized to https://example.com/, but %q given", d, actual)
+		}
+	}
+	if NormalizeDestination("example.com/Sale") == NormalizeDestination("example.com/sale") {
+		t.Errorf("Paths MUST stay case sensitive")
+	}
+}
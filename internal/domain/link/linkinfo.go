@@ -0,0 +1,144 @@
+package link
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type LinkInfo struct {
+	Key       string
+	RealLink  string
+	CreatedAt time.Time
+	// ActiveFrom is the start of a scheduled link, zero means it works since creation.
+	ActiveFrom time.Time
+	ExpiresAt  time.Time
+	UseCounter uint64
+	// PasswordHash is the bcrypt hash of the password of the link, empty when there is none.
+	PasswordHash string
+	// MaxClicks is the number of redirects after which the link stops working, zero means unlimited.
+	MaxClicks uint64
+	// Paused links don't redirect until their owner resumes them.
+	Paused bool
+	// DeletedAt is when the link was moved to the trash, zero for links which are not there.
+	DeletedAt time.Time
+}
+
+// Statuses of links shown to their owners.
+const (
+	StatusActive    = "active"
+	StatusExpired   = "expired"
+	StatusExhausted = "exhausted"
+	StatusScheduled = "scheduled"
+	StatusPaused    = "paused"
+	StatusDeleted   = "deleted"
+)
+
+// Exhausted reports whether the link has used up its redirects.
+func (i LinkInfo) Exhausted() bool {
+	return i.MaxClicks != 0 && i.UseCounter >= i.MaxClicks
+}
+
+// Status tells whether the link works at now and why it doesn't.
+func (i LinkInfo) Status(now time.Time) string {
+	switch {
+	case !i.DeletedAt.IsZero():
+		return StatusDeleted
+	case Expired(i.ExpiresAt, now):
+		return StatusExpired
+	case i.Exhausted():
+		return StatusExhausted
+	case i.Paused:
+		return StatusPaused
+	case NotActive(i.ActiveFrom, now):
+		return StatusScheduled
+	default:
+		return StatusActive
+	}
+}
+
+// DestinationChange is a previous destination of a link, which was replaced at ReplacedAt.
+type DestinationChange struct {
+	RealLink   string
+	ReplacedAt time.Time
+}
+
+const (
+	SortByCreated = "created"
+	SortByClicks  = "clicks"
+)
+
+// ListQuery selects a page of links of an account.
+type ListQuery struct {
+	SortBy    string
+	Ascending bool
+	// Contains keeps only links whose destination contains the substring, ignoring case.
+	Contains string
+	// Cursor is a position returned with the previous page, empty for the first page.
+	Cursor string
+	Limit  int
+}
+
+type LinkPage struct {
+	Links []LinkInfo
+	// NextCursor is empty when there are no more links.
+	NextCursor string
+}
+
+// Cursor is a position in a sorted link list: the sort value of the last
+// seen link and its key, which breaks ties.
+type Cursor struct {
+	Value int64
+	Key   string
+}
+
+// SortValue returns the value of the link the list is sorted by.
+func (i LinkInfo) SortValue(sortBy string) int64 {
+	if sortBy == SortByClicks {
+		return int64(i.UseCounter)
+	}
+	return i.CreatedAt.UnixNano()
+}
+
+// After reports whether the link goes after the cursor in the given order.
+func (c Cursor) After(i LinkInfo, sortBy string, ascending bool) bool {
+	v := i.SortValue(sortBy)
+	switch {
+	case v != c.Value:
+		return (v > c.Value) == ascending
+	case i.Key != c.Key:
+		return (i.Key > c.Key) == ascending
+	default:
+		return false
+	}
+}
+
+// CursorAt returns the position of the link in a list sorted by sortBy.
+func CursorAt(i LinkInfo, sortBy string) Cursor {
+	return Cursor{Value: i.SortValue(sortBy), Key: i.Key}
+}
+
+func (c Cursor) String() string {
+	s := strconv.FormatInt(c.Value, 10) + ":" + c.Key
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	v, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Value: v, Key: parts[1]}, nil
+}
@@ -1,17 +1,141 @@
 package link
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
-	ErrNotExist = errors.New("link does not exist")
+	ErrNotExist   = errors.New("link does not exist")
+	ErrExpired    = errors.New("link has expired")
+	ErrAliasTaken = errors.New("alias is already taken")
+	ErrForbidden  = errors.New("link belongs to another account")
+	ErrExhausted  = errors.New("link has reached its click limit")
+	ErrNotActive  = errors.New("link is not active yet")
+	ErrPaused     = errors.New("link is paused by its owner")
 )
 
+// KeyIdBlockSize is the number of ids reserved at once by ReserveKeyIds.
+const KeyIdBlockSize = 1000
+
+// Settings are per-account preferences and limits of link creation.
+type Settings struct {
+	// MaxTTL limits the lifetime of links created by the account, zero means
+	// that the service wide default applies.
+	MaxTTL time.Duration
+	// Alphabet and KeyLength are the account defaults of generated keys,
+	// empty values mean that the service defaults apply.
+	Alphabet  string
+	KeyLength int
+}
+
+// NewLink is a link to store under the Key. UseCounter is not zero for links
+// moved from another storage.
+type NewLink struct {
+	RealLink   string
+	Key        string
+	ActiveFrom time.Time
+	ExpiresAt  time.Time
+	UseCounter uint64
+	// MaxClicks limits the number of redirects, zero means unlimited.
+	MaxClicks uint64
+	// PasswordHash is a bcrypt hash of the link password, empty for public links.
+	PasswordHash string
+}
+
 type Interface interface {
-	CreateShortLink(link string, userId string) (string, error)
+	// CreateShortLinks stores the links of the user at once. The result has an
+	// error for every link: nil when it is stored, ErrAliasTaken when its key
+	// is in use, including keys repeated in the links.
+	CreateShortLinks(userId string, links []NewLink) ([]error, error)
 	GetLinkByKey(key string) (string, error)
+	// GetLinkInfo returns the link if it works, otherwise the error of the first failed check:
+	//  - ErrNotExist if there is no such link or it is in the trash,
+	//  - ErrExpired if it has expired,
+	//  - ErrExhausted if it has used up its redirects,
+	//  - ErrPaused if it is paused,
+	//  - ErrNotActive if its ActiveFrom has not come yet.
+	GetLinkInfo(key string) (LinkInfo, error)
+	// ReserveKeyIds returns the first of KeyIdBlockSize ids which are given
+	// to nobody else, they are turned into keys of new links.
+	ReserveKeyIds() (uint64, error)
+	// ForEachKey calls fn with keys of all the links out of the trash,
+	// including expired ones, and stops at the first error of fn.
+	ForEachKey(fn func(key string) error) error
+	// MakeRedirect counts the redirect and returns the destination. The click
+	// limit is checked in the same step, so concurrent redirects can't exceed it.
 	MakeRedirect(key string) (string, error)
+	// IncreaseUseCounters adds the counts of redirects to the links by keys,
+	// keys of links which don't exist anymore are skipped.
+	IncreaseUseCounters(counts map[string]uint64) error
+	// FindUserLink returns the latest working link of the user, anonymous when
+	// userId is empty, to the destination equal to realLink after NormalizeDestination.
+	// Only plain links are found: without a password, a click limit or a start time.
+	FindUserLink(userId string, realLink string) (LinkInfo, error)
+	// GetLinkOwner returns id of the account which created the link,
+	// empty for anonymous links.
+	GetLinkOwner(key string) (string, error)
+	// Methods taking userId act only on links of that account and return
+	// ErrForbidden for links of others. Links in the trash are not found by
+	// them, nor by the methods above.
+	//
+	// DeleteLink moves the link to the trash, it doesn't redirect there but
+	// keeps its key and stats until PurgeTrash.
 	DeleteLink(key string, userId string) (string, error)
-	GetUserLinks(userId string) ([]string, error)
-	GetLinkStat(link string) (uint64, error)
+	// GetTrash returns deleted links of the user from the latest deleted.
+	GetTrash(userId string) ([]LinkInfo, error)
+	// RestoreLink brings the link back from the trash, ErrNotExist is
+	// returned if it isn't there.
+	RestoreLink(key string, userId string) error
+	// PurgeTrash drops links deleted before deletedBefore with their history
+	// and clicks and returns their number. Their keys stay taken, so they
+	// are never given to other links.
+	PurgeTrash(deletedBefore time.Time) (int, error)
+	// UpdateLink changes the destination of the link and keeps the previous one in its history.
+	UpdateLink(key string, userId string, realLink string) error
+	// GetLinkHistory returns previous destinations of the link from the latest one.
+	GetLinkHistory(key string, userId string) ([]DestinationChange, error)
+	GetUserLinks(userId string, query ListQuery) (LinkPage, error)
+	GetLinkStat(key string, userId string) (uint64, error)
+	SetExpiration(key string, userId string, expiresAt time.Time) error
+	// SetPasswordHash protects the link with a password, empty hash removes the protection.
+	SetPasswordHash(key string, userId string, passwordHash string) error
+	// SetPaused stops redirects of the link or resumes them, the link keeps its stats.
+	SetPaused(key string, userId string, paused bool) error
 	CreateUserLinksStorage(userId string) (string, error)
-}
\ No newline at end of file
+	GetUserSettings(userId string) (Settings, error)
+	SaveUserSettings(userId string, settings Settings) error
+	SaveClicks(clicks []Click) error
+	// CountClicks returns only non-empty buckets ordered by time.
+	CountClicks(query ClickQuery) ([]ClickBucket, error)
+	// SaveVisitorSketches merges the sketches into stored ones of the same link and hour.
+	SaveVisitorSketches(sketches []VisitorSketch) error
+	// GetVisitorSketches returns sketches of hours in [from, to).
+	GetVisitorSketches(key string, from time.Time, to time.Time) ([]VisitorSketch, error)
+	// TopClickValues returns values ordered by number of clicks, most clicked first.
+	TopClickValues(query BreakdownQuery) ([]ValueCount, error)
+}
+
+// CreateShortLink stores a single link under the given key with CreateShortLinks.
+// ErrAliasTaken is returned if the key is in use.
+func CreateShortLink(storage Interface, link string, userId string, key string, expiresAt time.Time) (string, error) {
+	errs, err := storage.CreateShortLinks(userId, []NewLink{{RealLink: link, Key: key, ExpiresAt: expiresAt}})
+	if err != nil {
+		return "", err
+	}
+	if errs[0] != nil {
+		return "", errs[0]
+	}
+	return key, nil
+}
+
+// Expired reports whether a link with the given expiration time is dead at now.
+// Zero expiresAt means the link never expires.
+func Expired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// NotActive reports whether a link scheduled from activeFrom has not started at now.
+func NotActive(activeFrom time.Time, now time.Time) bool {
+	return !activeFrom.IsZero() && now.Before(activeFrom)
+}
@@ -0,0 +1,58 @@
+// Package geoip locates clients with a local MaxMind format database,
+// e.g. GeoLite2-City.mmdb, without calling any external service.
+package geoip
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	link2 "koro.che/internal/domain/link"
+	"net"
+)
+
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+func Open(path string) (*Reader, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// record is the part of GeoIP2/GeoLite2 Country and City records we need.
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// Locate returns an empty location for unknown and invalid addresses.
+func (r *Reader) Locate(ip string) link2.GeoLocation {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return link2.GeoLocation{}
+	}
+	var rec record
+	if err := r.db.Lookup(parsed, &rec); err != nil {
+		return link2.GeoLocation{}
+	}
+	loc := link2.GeoLocation{
+		Country: rec.Country.IsoCode,
+		City:    rec.City.Names["en"],
+	}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].Names["en"]
+	}
+	return loc
+}
+
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,157 @@
+// Package hll implements HyperLogLog sketches for approximate counting of
+// distinct items. Sketches of the same precision can be merged, so counts over
+// long periods are computed from sketches of short ones.
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+var (
+	ErrPrecisionMismatch = errors.New("sketches have different precision")
+	ErrInvalidSketch     = errors.New("invalid sketch encoding")
+)
+
+const (
+	MinPrecision = 4
+	MaxPrecision = 16
+	// DefaultPrecision gives about 1.6% standard error with 4 KiB of registers.
+	DefaultPrecision = 12
+)
+
+type Sketch struct {
+	p         uint8
+	registers []uint8
+}
+
+func New(precision uint8) *Sketch {
+	if precision < MinPrecision {
+		precision = MinPrecision
+	}
+	if precision > MaxPrecision {
+		precision = MaxPrecision
+	}
+	return &Sketch{p: precision, registers: make([]uint8, 1<<precision)}
+}
+
+func (s *Sketch) Precision() uint8 {
+	return s.p
+}
+
+// Add counts an item by its 64-bit hash, hashes must be uniformly distributed.
+func (s *Sketch) Add(hash uint64) {
+	idx := hash >> (64 - s.p)
+	// the sentinel bit limits the rank when the rest of the hash is zero
+	rank := uint8(bits.LeadingZeros64(hash<<s.p|1<<(s.p-1))) + 1
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+	}
+}
+
+// Merge makes s count items of both sketches.
+func (s *Sketch) Merge(other *Sketch) error {
+	if s.p != other.p {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Estimate returns the approximate number of distinct items added.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(len(s.registers)) * m * m / sum
+	// linear counting is more precise for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+const (
+	encodingDense  = 0
+	encodingSparse = 1
+)
+
+// MarshalBinary encodes the sketch. Sketches with few non-empty registers,
+// which is the case for rarely clicked links, are stored as index-value pairs.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	nonEmpty := 0
+	for _, r := range s.registers {
+		if r != 0 {
+			nonEmpty++
+		}
+	}
+	if 3*nonEmpty >= len(s.registers) {
+		b := make([]byte, 2, 2+len(s.registers))
+		b[0], b[1] = s.p, encodingDense
+		return append(b, s.registers...), nil
+	}
+	b := make([]byte, 2, 2+3*nonEmpty)
+	b[0], b[1] = s.p, encodingSparse
+	for i, r := range s.registers {
+		if r != 0 {
+			b = append(b, byte(i>>8), byte(i), r)
+		}
+	}
+	return b, nil
+}
+
+func (s *Sketch) UnmarshalBinary(b []byte) error {
+	if len(b) < 2 || b[0] < MinPrecision || b[0] > MaxPrecision {
+		return ErrInvalidSketch
+	}
+	p := b[0]
+	registers := make([]uint8, 1<<p)
+	data := b[2:]
+	switch b[1] {
+	case encodingDense:
+		if len(data) != len(registers) {
+			return ErrInvalidSketch
+		}
+		copy(registers, data)
+	case encodingSparse:
+		if len(data)%3 != 0 {
+			return ErrInvalidSketch
+		}
+		for i := 0; i < len(data); i += 3 {
+			idx := int(binary.BigEndian.Uint16(data[i:]))
+			if idx >= len(registers) {
+				return ErrInvalidSketch
+			}
+			registers[idx] = data[i+2]
+		}
+	default:
+		return ErrInvalidSketch
+	}
+	s.p, s.registers = p, registers
+	return nil
+}
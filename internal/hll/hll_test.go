@@ -0,0 +1,73 @@
+package hll
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func assertClose(t *testing.T, expected int, actual uint64, tolerance float64) {
+	if math.Abs(float64(actual)-float64(expected)) > tolerance*float64(expected) {
+		t.Errorf("Estimate MUST be about %d, but %d given", expected, actual)
+	}
+}
+
+func Test_Estimate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{10, 1000, 100000} {
+		s := New(DefaultPrecision)
+		for i := 0; i < n; i++ {
+			h := r.Uint64()
+			// repeated visits don't change the estimate
+			s.Add(h)
+			s.Add(h)
+		}
+		assertClose(t, n, s.Estimate(), 0.05)
+	}
+}
+
+func Test_Merge(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	a, b := New(DefaultPrecision), New(DefaultPrecision)
+	for i := 0; i < 5000; i++ {
+		h := r.Uint64()
+		a.Add(h)
+		if i%2 == 0 {
+			b.Add(h)
+		}
+		b.Add(r.Uint64())
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	assertClose(t, 10000, a.Estimate(), 0.05)
+
+	if err := a.Merge(New(DefaultPrecision + 1)); err != ErrPrecisionMismatch {
+		t.Errorf("Merge MUST return %v, but %v given", ErrPrecisionMismatch, err)
+	}
+}
+
+func Test_Marshal(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, n := range []int{3, 100000} {
+		s := New(DefaultPrecision)
+		for i := 0; i < n; i++ {
+			s.Add(r.Uint64())
+		}
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+		var decoded Sketch
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if decoded.Estimate() != s.Estimate() {
+			t.Errorf("Decoded sketch MUST estimate %d, but %d given", s.Estimate(), decoded.Estimate())
+		}
+	}
+	var s Sketch
+	if err := s.UnmarshalBinary([]byte{DefaultPrecision, encodingSparse, 1}); err != ErrInvalidSketch {
+		t.Errorf("Unmarshal MUST return %v, but %v given", ErrInvalidSketch, err)
+	}
+}
@@ -0,0 +1,199 @@
+package linkrepo
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"koro.che/internal/bloom"
+	link2 "koro.che/internal/domain/link"
+	"sync"
+	"time"
+)
+
+var (
+	filterLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "link_key_filter_lookups_total",
+		Help: "Redirect lookups by the answer of the key filter: rejected, passed or false_positive",
+	}, []string{"result"})
+	filterFalsePositiveRate = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "link_key_filter_false_positive_rate",
+		Help: "Estimated probability of the key filter passing a key which does not exist",
+	})
+)
+
+// minFilterKeys keeps room in the filter of a new database.
+const minFilterKeys = 100000
+
+// Filter rejects redirects to unknown keys with a Bloom filter of all the keys
+// in front of another storage. It only learns about links created or restored
+// through it, so links of other instances are not found until the next
+// rebuild: the filter is meant for a single instance serving all the links.
+type Filter struct {
+	link2.Interface
+	falsePositiveRate float64
+	logger            zerolog.Logger
+
+	mu     sync.RWMutex
+	filter *bloom.Filter
+	// added collects keys created during a rebuild, they may be missing in the new filter.
+	added      []string
+	rebuilding bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New builds the filter of all the keys in the storage and rebuilds it every
+// rebuildInterval to drop deleted keys.
+func New(storage link2.Interface, falsePositiveRate float64, rebuildInterval time.Duration) (*Filter, error) {
+	f := &Filter{
+		Interface:         storage,
+		falsePositiveRate: falsePositiveRate,
+		logger:            log.With().Str("module", "key-filter").Logger(),
+		done:              make(chan struct{}),
+	}
+	if err := f.Rebuild(); err != nil {
+		return nil, err
+	}
+	f.wg.Add(1)
+	go f.run(rebuildInterval)
+	return f, nil
+}
+
+// Close stops rebuilds.
+func (f *Filter) Close() {
+	close(f.done)
+	f.wg.Wait()
+}
+
+func (f *Filter) run(rebuildInterval time.Duration) {
+	defer f.wg.Done()
+	ticker := time.NewTicker(rebuildInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			if err := f.Rebuild(); err != nil {
+				f.logger.Error().Err(err).Msg("failed to rebuild key filter")
+			}
+		}
+	}
+}
+
+// Rebuild replaces the filter with a new one made of the keys in the storage.
+func (f *Filter) Rebuild() error {
+	f.mu.Lock()
+	f.rebuilding = true
+	f.added = nil
+	f.mu.Unlock()
+
+	var keys []string
+	err := f.Interface.ForEachKey(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rebuilding = false
+	if err != nil {
+		return err
+	}
+	// the filter grows until the next rebuild, so it is made for twice as many keys
+	size := 2 * len(keys)
+	if size < minFilterKeys {
+		size = minFilterKeys
+	}
+	filter := bloom.New(size, f.falsePositiveRate)
+	for _, key := range keys {
+		filter.Add(key)
+	}
+	for _, key := range f.added {
+		filter.Add(key)
+	}
+	f.added = nil
+	f.filter = filter
+	filterFalsePositiveRate.Set(filter.FalsePositiveRate())
+	return nil
+}
+
+func (f *Filter) GetLinkByKey(key string) (string, error) {
+	if !f.mayExist(key) {
+		return "", link2.ErrNotExist
+	}
+	realLink, err := f.Interface.GetLinkByKey(key)
+	f.checkFalsePositive(err)
+	return realLink, err
+}
+
+func (f *Filter) GetLinkInfo(key string) (link2.LinkInfo, error) {
+	if !f.mayExist(key) {
+		return link2.LinkInfo{}, link2.ErrNotExist
+	}
+	info, err := f.Interface.GetLinkInfo(key)
+	f.checkFalsePositive(err)
+	return info, err
+}
+
+func (f *Filter) MakeRedirect(key string) (string, error) {
+	if !f.mayExist(key) {
+		return "", link2.ErrNotExist
+	}
+	realLink, err := f.Interface.MakeRedirect(key)
+	f.checkFalsePositive(err)
+	return realLink, err
+}
+
+func (f *Filter) CreateShortLinks(userId string, links []link2.NewLink) ([]error, error) {
+	errs, err := f.Interface.CreateShortLinks(userId, links)
+	if err != nil {
+		return errs, err
+	}
+	for i, l := range links {
+		if errs[i] == nil {
+			f.add(l.Key)
+		}
+	}
+	return errs, nil
+}
+
+// RestoreLink adds the key back, it may be dropped by a rebuild while the link is in the trash.
+func (f *Filter) RestoreLink(key string, userId string) error {
+	err := f.Interface.RestoreLink(key, userId)
+	if err == nil {
+		f.add(key)
+	}
+	return err
+}
+
+func (f *Filter) add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.filter.Add(key)
+	if f.rebuilding {
+		f.added = append(f.added, key)
+	}
+	filterFalsePositiveRate.Set(f.filter.FalsePositiveRate())
+}
+
+func (f *Filter) mayExist(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.filter.MayContain(key) {
+		filterLookups.WithLabelValues("rejected").Inc()
+		return false
+	}
+	return true
+}
+
+// checkFalsePositive counts lookups which the filter passed, err is the answer of the storage.
+func (f *Filter) checkFalsePositive(err error) {
+	if err == link2.ErrNotExist {
+		filterLookups.WithLabelValues("false_positive").Inc()
+	} else {
+		filterLookups.WithLabelValues("passed").Inc()
+	}
+}
@@ -0,0 +1,58 @@
+package linkrepo
+
+import (
+	link2 "koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+// countingStorage counts lookups which reach the storage behind the filter.
+type countingStorage struct {
+	*linkrepo.Memory
+	lookups int
+}
+
+func (s *countingStorage) MakeRedirect(key string) (string, error) {
+	s.lookups++
+	return s.Memory.MakeRedirect(key)
+}
+
+func Test_Filter(t *testing.T) {
+	storage := &countingStorage{Memory: linkrepo.NewMemory()}
+	storage.CreateUserLinksStorage("alice")
+	link2.CreateShortLink(storage, "example.com", "alice", "old", time.Time{})
+	f, err := New(storage, 0.001, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to build filter: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.MakeRedirect("random"); err != link2.ErrNotExist || storage.lookups != 0 {
+		t.Errorf("Unknown key MUST be rejected by the filter, but %v with %d lookups given", err, storage.lookups)
+	}
+	if realLink, err := f.MakeRedirect("old"); err != nil || realLink != "example.com" {
+		t.Errorf("Link of the storage MUST be found, but %q (%v) given", realLink, err)
+	}
+	link2.CreateShortLink(f, "example.org", "alice", "new", time.Time{})
+	if realLink, err := f.MakeRedirect("new"); err != nil || realLink != "example.org" {
+		t.Errorf("Created link MUST be found, but %q (%v) given", realLink, err)
+	}
+
+	f.DeleteLink("old", "alice")
+	if err := f.Rebuild(); err != nil {
+		t.Fatalf("failed to rebuild filter: %v", err)
+	}
+	storage.lookups = 0
+	if _, err := f.MakeRedirect("old"); err != link2.ErrNotExist || storage.lookups != 0 {
+		t.Errorf("Deleted key MUST be rejected after rebuild, but %v with %d lookups given", err, storage.lookups)
+	}
+	if _, err := f.MakeRedirect("new"); err != nil {
+		t.Errorf("Link MUST be found after rebuild, but %v given", err)
+	}
+
+	f.RestoreLink("old", "alice")
+	if realLink, err := f.MakeRedirect("old"); err != nil || realLink != "example.com" {
+		t.Errorf("Restored link MUST be found, but %q (%v) given", realLink, err)
+	}
+}
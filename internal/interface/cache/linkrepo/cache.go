@@ -0,0 +1,201 @@
+package linkrepo
+
+import (
+	"container/list"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	link2 "koro.che/internal/domain/link"
+	"sync"
+	"time"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "link_cache_requests_total",
+	Help: "Lookups of links in the redirect cache by result",
+}, []string{"result"})
+
+// Cache keeps recently used links in memory in front of another storage, so
+// redirects of hot links don't query it. Links changed through the cache are
+// invalidated at once, changes made by other instances of the service are
+// seen after the TTL.
+type Cache struct {
+	link2.Interface
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation changes on every invalidation, so lookups which started
+	// before it don't put stale links into the cache.
+	generation uint64
+}
+
+type entry struct {
+	key        string
+	info       link2.LinkInfo
+	err        error
+	validUntil time.Time
+}
+
+// New caches up to size links for ttl. Missing, expired, exhausted and paused
+// links are cached for negativeTTL.
+func New(storage link2.Interface, size int, ttl time.Duration, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		Interface:   storage,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+func (c *Cache) GetLinkByKey(key string) (string, error) {
+	info, err := c.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	return info.RealLink, nil
+}
+
+// GetLinkInfo returns the cached link, its UseCounter may be behind the storage.
+func (c *Cache) GetLinkInfo(key string) (link2.LinkInfo, error) {
+	return c.lookup(key)
+}
+
+func (c *Cache) MakeRedirect(key string) (string, error) {
+	info, err := c.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	if info.MaxClicks != 0 {
+		// the cached counter is stale, only the storage knows whether a click is left
+		realLink, err := c.Interface.MakeRedirect(key)
+		if err == link2.ErrExhausted {
+			c.invalidate(key)
+		}
+		return realLink, err
+	}
+	err = c.Interface.IncreaseUseCounters(map[string]uint64{key: 1})
+	return info.RealLink, err
+}
+
+func (c *Cache) CreateShortLinks(userId string, links []link2.NewLink) ([]error, error) {
+	errs, err := c.Interface.CreateShortLinks(userId, links)
+	if err != nil {
+		return errs, err
+	}
+	for i, l := range links {
+		if errs[i] == nil {
+			// the key may be cached as missing
+			c.invalidate(l.Key)
+		}
+	}
+	return errs, nil
+}
+
+func (c *Cache) DeleteLink(key string, userId string) (string, error) {
+	defer c.invalidate(key)
+	return c.Interface.DeleteLink(key, userId)
+}
+
+func (c *Cache) RestoreLink(key string, userId string) error {
+	defer c.invalidate(key)
+	return c.Interface.RestoreLink(key, userId)
+}
+
+func (c *Cache) UpdateLink(key string, userId string, realLink string) error {
+	defer c.invalidate(key)
+	return c.Interface.UpdateLink(key, userId, realLink)
+}
+
+func (c *Cache) SetPaused(key string, userId string, paused bool) error {
+	defer c.invalidate(key)
+	return c.Interface.SetPaused(key, userId, paused)
+}
+
+func (c *Cache) SetPasswordHash(key string, userId string, passwordHash string) error {
+	defer c.invalidate(key)
+	return c.Interface.SetPasswordHash(key, userId, passwordHash)
+}
+
+func (c *Cache) SetExpiration(key string, userId string, expiresAt time.Time) error {
+	defer c.invalidate(key)
+	return c.Interface.SetExpiration(key, userId, expiresAt)
+}
+
+func (c *Cache) lookup(key string) (link2.LinkInfo, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.get(key, now)
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		cacheRequests.WithLabelValues("hit").Inc()
+		if e.err == nil && link2.Expired(e.info.ExpiresAt, now) {
+			return link2.LinkInfo{}, link2.ErrExpired
+		}
+		return e.info, e.err
+	}
+	cacheRequests.WithLabelValues("miss").Inc()
+
+	info, err := c.Interface.GetLinkInfo(key)
+	e = entry{key: key, info: info, err: err, validUntil: now.Add(c.ttl)}
+	switch err {
+	case nil:
+	case link2.ErrNotExist, link2.ErrExpired, link2.ErrExhausted, link2.ErrPaused:
+		e.validUntil = now.Add(c.negativeTTL)
+	default:
+		// links which are not active yet aren't cached either, so they start on time
+		return info, err
+	}
+	c.mu.Lock()
+	if c.generation == generation {
+		c.put(e)
+	}
+	c.mu.Unlock()
+	return info, err
+}
+
+// get must be called with c.mu held.
+func (c *Cache) get(key string, now time.Time) (entry, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return entry{}, false
+	}
+	e := el.Value.(entry)
+	if !now.Before(e.validUntil) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return entry{}, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// put must be called with c.mu held.
+func (c *Cache) put(e entry) {
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(entry).key)
+	}
+}
+
+func (c *Cache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,185 @@
+package linkrepo
+
+import (
+	link2 "koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"koro.che/internal/usecases/link"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingStorage counts lookups which reach the storage behind the cache.
+type countingStorage struct {
+	*linkrepo.Memory
+	lookups int
+}
+
+func (s *countingStorage) GetLinkInfo(key string) (link2.LinkInfo, error) {
+	s.lookups++
+	return s.Memory.GetLinkInfo(key)
+}
+
+func newTestCache(size int) (*Cache, *countingStorage) {
+	storage := &countingStorage{Memory: linkrepo.NewMemory()}
+	storage.CreateUserLinksStorage("alice")
+	return New(storage, size, time.Minute, time.Minute), storage
+}
+
+func Test_CacheHits(t *testing.T) {
+	c, storage := newTestCache(10)
+	link2.CreateShortLink(c, "example.com", "alice", "sale", time.Time{})
+	for i := 0; i < 3; i++ {
+		if realLink, err := c.MakeRedirect("sale"); err != nil || realLink != "example.com" {
+			t.Fatalf("Redirect MUST lead to example.com, but %q (%v) given", realLink, err)
+		}
+	}
+	if storage.lookups != 1 {
+		t.Errorf("Storage MUST be queried once, but %d lookups given", storage.lookups)
+	}
+	if n, _ := c.GetLinkStat("sale", "alice"); n != 3 {
+		t.Errorf("Cached redirects MUST be counted, but %d uses given", n)
+	}
+}
+
+func Test_CacheMaxClicks(t *testing.T) {
+	c, _ := newTestCache(10)
+	c.CreateShortLinks("alice", []link2.NewLink{{RealLink: "example.com", Key: "invite", MaxClicks: 2}})
+	for i := 0; i < 2; i++ {
+		if _, err := c.MakeRedirect("invite"); err != nil {
+			t.Fatalf("Redirect %d MUST succeed, but %v given", i, err)
+		}
+	}
+	if _, err := c.MakeRedirect("invite"); err != link2.ErrExhausted {
+		t.Errorf("Cached link MUST NOT pass its click limit, but %v given", err)
+	}
+	if _, err := c.GetLinkInfo("invite"); err != link2.ErrExhausted {
+		t.Errorf("Exhausted link MUST NOT stay cached, but %v given", err)
+	}
+}
+
+func Test_CachePause(t *testing.T) {
+	c, _ := newTestCache(10)
+	link2.CreateShortLink(c, "example.com", "alice", "sale", time.Time{})
+	c.MakeRedirect("sale")
+
+	c.SetPaused("sale", "alice", true)
+	if _, err := c.MakeRedirect("sale"); err != link2.ErrPaused {
+		t.Errorf("Paused link MUST NOT be served from the cache, but %v given", err)
+	}
+	c.SetPaused("sale", "alice", false)
+	if _, err := c.MakeRedirect("sale"); err != nil {
+		t.Errorf("Resumed link MUST redirect at once, but %v given", err)
+	}
+}
+
+func Test_CacheRestore(t *testing.T) {
+	c, _ := newTestCache(10)
+	link2.CreateShortLink(c, "example.com", "alice", "sale", time.Time{})
+	c.DeleteLink("sale", "alice")
+	if _, err := c.MakeRedirect("sale"); err != link2.ErrNotExist {
+		t.Fatalf("Deleted link MUST NOT redirect, but %v given", err)
+	}
+	c.RestoreLink("sale", "alice")
+	if _, err := c.MakeRedirect("sale"); err != nil {
+		t.Errorf("Restored link MUST redirect at once, but %v given", err)
+	}
+}
+
+func Test_CacheInvalidation(t *testing.T) {
+	c, storage := newTestCache(10)
+
+	if _, err := c.GetLinkByKey("sale"); err != link2.ErrNotExist {
+		t.Fatalf("Missing link MUST give %v, but %v given", link2.ErrNotExist, err)
+	}
+	c.GetLinkByKey("sale")
+	if storage.lookups != 1 {
+		t.Errorf("Missing link MUST be cached, but %d lookups given", storage.lookups)
+	}
+
+	link2.CreateShortLink(c, "example.com", "alice", "sale", time.Time{})
+	if realLink, err := c.GetLinkByKey("sale"); err != nil || realLink != "example.com" {
+		t.Errorf("Created link MUST be found, but %q (%v) given", realLink, err)
+	}
+
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	c.SetExpiration("sale", "alice", expiresAt)
+	if _, err := c.GetLinkByKey("sale"); err != nil {
+		t.Errorf("Link MUST work before expiration, but %v given", err)
+	}
+	time.Sleep(time.Until(expiresAt))
+	if _, err := c.GetLinkByKey("sale"); err != link2.ErrExpired {
+		t.Errorf("Cached link MUST expire in time, but %v given", err)
+	}
+
+	c.SetExpiration("sale", "alice", time.Time{})
+	c.GetLinkByKey("sale")
+	c.UpdateLink("sale", "alice", "example.org")
+	if realLink, err := c.GetLinkByKey("sale"); err != nil || realLink != "example.org" {
+		t.Errorf("Updated link MUST lead to the new destination, but %q (%v) given", realLink, err)
+	}
+
+	c.DeleteLink("sale", "alice")
+	if _, err := c.GetLinkByKey("sale"); err != link2.ErrNotExist {
+		t.Errorf("Deleted link MUST NOT be found, but %v given", err)
+	}
+}
+
+func Test_CacheEviction(t *testing.T) {
+	c, storage := newTestCache(2)
+	for _, key := range []string{"one", "two", "three"} {
+		link2.CreateShortLink(c, "example.com", "alice", key, time.Time{})
+	}
+	c.GetLinkByKey("one")
+	c.GetLinkByKey("two")
+	c.GetLinkByKey("one")
+	c.GetLinkByKey("three") // evicts two as the least recently used
+	storage.lookups = 0
+	c.GetLinkByKey("one")
+	c.GetLinkByKey("two")
+	if storage.lookups != 1 {
+		t.Errorf("Only the least recently used link MUST be evicted, but %d lookups given", storage.lookups)
+	}
+}
+
+func Test_CacheRedirectsOfUseCases(t *testing.T) {
+	c, storage := newTestCache(10)
+	l := link.LinkUseCases{LinkStorage: c}
+	created, err := l.ShortenLink("example.com/sale", "alice", link.ShortenOptions{})
+	if err != nil {
+		t.Fatalf("failed to shorten link: %v", err)
+	}
+	key := created.ShortLink[strings.LastIndex(created.ShortLink, "/")+1:]
+	browser := link.Visit{UserAgent: "Firefox"}
+
+	for i := 0; i < 5; i++ {
+		if realLink, err := l.MakeRedirect(key, browser); err != nil || realLink != "example.com/sale" {
+			t.Fatalf("Redirect MUST lead to example.com/sale, but %q (%v) given", realLink, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := l.MakeRedirect("missing", browser); err != link2.ErrNotExist {
+			t.Fatalf("Missing link MUST give %v, but %v given", link2.ErrNotExist, err)
+		}
+	}
+	if storage.lookups != 2 {
+		t.Errorf("Storage MUST be queried once per key, but %d lookups given", storage.lookups)
+	}
+
+	if err := l.PauseLink(key, "alice"); err != nil {
+		t.Fatalf("failed to pause link: %v", err)
+	}
+	if _, err := l.MakeRedirect(key, browser); err != link2.ErrPaused {
+		t.Errorf("Paused link MUST NOT be served from the cache, but %v given", err)
+	}
+	if err := l.SetLinkPassword(key, "alice", "open sesame"); err != nil {
+		t.Fatalf("failed to set password: %v", err)
+	}
+	l.ResumeLink(key, "alice")
+	if _, err := l.MakeRedirect(key, browser); err != link.ErrPasswordRequired {
+		t.Errorf("Protected link MUST NOT be served from the cache, but %v given", err)
+	}
+	if storage.lookups != 4 {
+		t.Errorf("Changes MUST invalidate the cached link, but %d lookups given", storage.lookups)
+	}
+}
@@ -8,10 +8,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	link2 "koro.che/internal/domain/link"
 	"koro.che/internal/interface/prom"
 	"koro.che/internal/usecases/account"
 	"koro.che/internal/usecases/link"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,6 +23,12 @@ type Api struct {
 	AccountUseCases account.AccountUseCasesInterface
 	LinkUseCases    link.LinkUseCasesInterface
 	Logger zerolog.Logger
+	// TrustedProxies may pass the client address in X-Forwarded-For.
+	TrustedProxies []*net.IPNet
+	// NotActive answers redirects of scheduled links before their start.
+	NotActive StatusPage
+	// Paused answers redirects of links paused by their owners.
+	Paused StatusPage
 }
 
 func NewApi(a account.AccountUseCasesInterface, l link.LinkUseCasesInterface) *Api {
@@ -26,6 +36,8 @@ func NewApi(a account.AccountUseCasesInterface, l link.LinkUseCasesInterface) *A
 		AccountUseCases: a,
 		LinkUseCases:    l,
 		Logger: log.With().Str("module", "http-server").Logger(),
+		NotActive:       StatusPage{Status: http.StatusNotFound},
+		Paused:          StatusPage{Status: http.StatusServiceUnavailable},
 	}
 }
 
@@ -38,10 +50,26 @@ func (a *Api) Router() http.Handler {
 	router.HandleFunc("/api/login", a.login).Methods(http.MethodPut)
 	router.HandleFunc("/api/logout", a.authorize(a.logout)).Methods(http.MethodPut)
 	router.HandleFunc("/api/shorten", a.shortenLink).Methods(http.MethodPost)
+	router.HandleFunc("/api/shorten/batch", a.shortenLinks).Methods(http.MethodPost)
 	router.HandleFunc("/api/{key}/real", a.getRealLink).Methods(http.MethodGet)
-	router.HandleFunc("/{key}", a.redirectToRealLink).Methods(http.MethodGet)
+	router.HandleFunc("/{key}", a.redirectToRealLink).Methods(http.MethodGet, http.MethodHead, http.MethodPost)
+	router.HandleFunc("/api/manage/trash", a.authorize(a.getTrash)).Methods(http.MethodGet)
 	router.HandleFunc("/api/manage/{key}", a.authorize(a.deleteLink)).Methods(http.MethodDelete)
+	router.HandleFunc("/api/manage/{key}", a.authorize(a.updateLink)).Methods(http.MethodPatch)
+	router.HandleFunc("/api/manage/{key}/password", a.authorize(a.setLinkPassword)).Methods(http.MethodPut)
+	router.HandleFunc("/api/manage/{key}/password", a.authorize(a.removeLinkPassword)).Methods(http.MethodDelete)
+	router.HandleFunc("/api/manage/{key}/history", a.authorize(a.getLinkHistory)).Methods(http.MethodGet)
+	router.HandleFunc("/api/manage/{key}/expiration", a.authorize(a.setLinkExpiration)).Methods(http.MethodPut)
+	router.HandleFunc("/api/manage/{key}/restore", a.authorize(a.restoreLink)).Methods(http.MethodPost)
+	router.HandleFunc("/api/manage/{key}/pause", a.authorize(a.pauseLink)).Methods(http.MethodPost)
+	router.HandleFunc("/api/manage/{key}/resume", a.authorize(a.resumeLink)).Methods(http.MethodPost)
+	router.HandleFunc("/api/manage/{key}/stats", a.authorize(a.getLinkTimeSeries)).Methods(http.MethodGet)
+	router.HandleFunc("/api/manage/{key}/breakdown", a.authorize(a.getLinkBreakdown)).Methods(http.MethodGet)
 	router.HandleFunc("/api/manage/links", a.authorize(a.getUserLinks)).Methods(http.MethodGet)
+	router.HandleFunc("/api/manage/export", a.authorize(a.exportLinks)).Methods(http.MethodGet)
+	router.HandleFunc("/api/manage/import", a.authorize(a.importLinks)).Methods(http.MethodPost)
+	router.HandleFunc("/api/manage/settings", a.authorize(a.getUserSettings)).Methods(http.MethodGet)
+	router.HandleFunc("/api/manage/settings", a.authorize(a.setUserSettings)).Methods(http.MethodPut)
 	router.HandleFunc("/api/manage/stats", a.authorize(a.getUserLinkStats)).Methods(http.MethodGet)
 
 	return router
@@ -134,23 +162,183 @@ func (a *Api) logout(writer http.ResponseWriter, request *http.Request) {
 	http.Redirect(writer, request, "/", http.StatusFound)
 }
 
+// redirectToRealLink answers with temporary redirects which are not cached, see
+// README.md about how soon changes of links are seen.
 func (a *Api) redirectToRealLink(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
-	if link, err := a.LinkUseCases.MakeRedirect(vars["key"]); err == nil {
-		http.Redirect(writer, request, "https://"+link, http.StatusMovedPermanently)
-	} else {
-		writer.WriteHeader(http.StatusNotFound)
+	visit := link.Visit{
+		Referrer:  request.Referer(),
+		UserAgent: request.UserAgent(),
+		IP:        a.clientIP(request),
+		Prefetch:  isPrefetch(request),
+		Password:  linkPassword(request),
+	}
+	realLink, err := a.LinkUseCases.MakeRedirect(vars["key"], visit)
+	switch {
+	case err == nil && request.Method == http.MethodPost:
+		// the unlock form is answered with a redirect which must not be cached
+		writer.Header().Set("Cache-Control", "no-store")
+		http.Redirect(writer, request, "https://"+realLink, http.StatusSeeOther)
+	case err == nil && visit.Password != "":
+		// the redirect is unlocked by the header, caches keyed by the URL must not keep it
+		writer.Header().Set("Cache-Control", "no-store")
+		http.Redirect(writer, request, "https://"+realLink, http.StatusFound)
+	case err == nil:
+		// links may be changed, paused or run out, so browsers must ask every time
+		writer.Header().Set("Cache-Control", "private, max-age=0")
+		http.Redirect(writer, request, "https://"+realLink, http.StatusFound)
+	case err == link.ErrBotOfLimitedLink:
+		// the click limit is kept for people, link previews get no destination
+		writer.Header().Set("Cache-Control", "no-store")
+		writer.WriteHeader(http.StatusNoContent)
+	case isLocked(err) && wantsHTML(request):
+		a.writeUnlockPage(writer, vars["key"], err)
+	case err == link2.ErrNotActive:
+		a.NotActive.write(writer, request)
+	case err == link2.ErrPaused:
+		a.Paused.write(writer, request)
+	default:
+		writer.WriteHeader(linkErrorStatus(err))
+	}
+}
+
+// clientIP returns the address of the client which sent the request. Addresses
+// from X-Forwarded-For are used only while they are added by trusted proxies.
+func (a *Api) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	var forwarded []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(h, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0 && a.isTrustedProxy(ip); i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			break
+		}
+		ip = forwarded[i]
+	}
+	return ip
+}
+
+// isPrefetch tells requests made in advance by browsers and link previews,
+// they are not clicks of people.
+func isPrefetch(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for _, h := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		v := strings.ToLower(r.Header.Get(h))
+		if strings.Contains(v, "prefetch") || strings.Contains(v, "preview") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Api) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range a.TrustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
 	}
+	return false
 }
 
 type linkModel struct {
 	Link string `json:"link"`
 }
 
+// lifetimeModel is a requested link lifetime: either an exact expiration
+// time or a TTL in seconds.
+type lifetimeModel struct {
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// ActiveUntil is the end of a scheduled link, another name of ExpiresAt.
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	TTL         int64      `json:"ttl,omitempty"`
+}
+
+func (m lifetimeModel) lifetime() link.Lifetime {
+	lt := link.Lifetime{TTL: time.Duration(m.TTL) * time.Second}
+	if m.ExpiresAt != nil {
+		lt.ExpiresAt = *m.ExpiresAt
+	} else if m.ActiveUntil != nil {
+		lt.ExpiresAt = *m.ActiveUntil
+	}
+	return lt
+}
+
+// keyPolicyModel restricts generated keys, empty fields fall back to defaults.
+type keyPolicyModel struct {
+	Alphabet  string `json:"alphabet,omitempty"`
+	KeyLength int    `json:"keyLength,omitempty"`
+}
+
+func (m keyPolicyModel) keyPolicy() link.KeyPolicy {
+	return link.KeyPolicy{Alphabet: m.Alphabet, Length: m.KeyLength}
+}
+
+type shortenModel struct {
+	Link  string `json:"link"`
+	Alias string `json:"alias,omitempty"`
+	// Reuse asks for an existing link to the same destination if there is one.
+	Reuse bool `json:"reuse,omitempty"`
+	// MaxClicks makes a link which stops working after the number of redirects.
+	MaxClicks uint64 `json:"maxClicks,omitempty"`
+	// ActiveFrom schedules the start of a link, it works at once when omitted.
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+	lifetimeModel
+	keyPolicyModel
+}
+
+func (m shortenModel) options() link.ShortenOptions {
+	opts := link.ShortenOptions{
+		Lifetime:      m.lifetime(),
+		Alias:         m.Alias,
+		KeyPolicy:     m.keyPolicy(),
+		ReuseExisting: m.Reuse,
+		MaxClicks:     m.MaxClicks,
+	}
+	if m.ActiveFrom != nil {
+		opts.ActiveFrom = *m.ActiveFrom
+	}
+	return opts
+}
+
+type shortenResultModel struct {
+	Link   string `json:"link"`
+	Reused bool   `json:"reused"`
+}
+
+// batchResultModel is a result of a batch item, Error and Status tell why it failed.
+type batchResultModel struct {
+	Link   string `json:"link,omitempty"`
+	Reused bool   `json:"reused,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status"`
+}
+
+type settingsModel struct {
+	keyPolicyModel
+	// MaxTTL is the account limit of link lifetime in seconds, it can't be changed by the user.
+	MaxTTL int64 `json:"maxTtl,omitempty"`
+}
+
+type expirationModel struct {
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
 func (a *Api) getRealLink(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 	vars := mux.Vars(request)
-	if link, err := a.LinkUseCases.GetRealLink(vars["key"]); err == nil {
+	if link, err := a.LinkUseCases.GetRealLink(vars["key"], request.Header.Get(linkPasswordHeader)); err == nil {
 		o := linkModel{Link: link}
 		if err := json.NewEncoder(writer).Encode(o); err != nil {
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -158,7 +346,42 @@ func (a *Api) getRealLink(writer http.ResponseWriter, request *http.Request) {
 		}
 		writer.WriteHeader(http.StatusOK)
 	} else {
-		writer.WriteHeader(http.StatusNotFound)
+		writer.WriteHeader(linkErrorStatus(err))
+	}
+}
+
+// linkErrorStatus maps link domain errors to HTTP status codes.
+func linkErrorStatus(err error) int {
+	switch err {
+	case link2.ErrNotExist, link2.ErrNotActive:
+		return http.StatusNotFound
+	case link2.ErrExpired, link2.ErrExhausted:
+		return http.StatusGone
+	case link2.ErrPaused:
+		return http.StatusServiceUnavailable
+	case link2.ErrForbidden:
+		return http.StatusForbidden
+	case link2.ErrAliasTaken:
+		return http.StatusConflict
+	case link.ErrLifetimeNotAllowed, link.ErrLifetimeTooLong, link.ErrExpirationInPast,
+		link.ErrInvalidAliasString, link.ErrTooShortAlias, link.ErrTooLongAlias, link.ErrReservedAlias,
+		link.ErrUnknownAlphabet, link.ErrInvalidKeyLength, link.ErrUnknownSort, link2.ErrInvalidCursor,
+		link.ErrUnknownInterval, link.ErrUnknownTimeZone, link.ErrInvalidTimeRange, link.ErrTooManyBuckets,
+		link.ErrUnknownDimension, link.ErrUnknownBotFilter, link.ErrEmptyDestination,
+		link.ErrInvalidLinkPassword, link.ErrEmptyActiveWindow, link.ErrInvalidPasswordHash, errPasswordNotExported:
+		return http.StatusBadRequest
+	case link.ErrPasswordRequired:
+		return http.StatusUnauthorized
+	case link.ErrWrongPassword:
+		return http.StatusForbidden
+	case link.ErrTooManyAttempts:
+		return http.StatusTooManyRequests
+	case link.ErrBatchTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case link.ErrKeySpaceExhausted:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
@@ -180,7 +403,7 @@ func GetUserId(a *Api, r *http.Request) string {
 
 func (a *Api) shortenLink(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
-	var m linkModel
+	var m shortenModel
 	if err := json.NewDecoder(request.Body).Decode(&m); err != nil {
 		writer.WriteHeader(http.StatusBadRequest)
 		return
@@ -189,42 +412,300 @@ func (a *Api) shortenLink(writer http.ResponseWriter, request *http.Request) {
 	// get user id if exists
 	userId := GetUserId(a, request)
 
-	var shortLink, _ = a.LinkUseCases.ShortenLink(m.Link, userId)
-	o := linkModel{Link: shortLink}
+	result, err := a.LinkUseCases.ShortenLink(m.Link, userId, m.options())
+	if err != nil {
+		writer.WriteHeader(linkErrorStatus(err))
+		writer.Write([]byte(err.Error()))
+		return
+	}
+	o := shortenResultModel{Link: result.ShortLink, Reused: result.Reused}
+	if result.Reused {
+		writer.WriteHeader(http.StatusOK)
+	} else {
+		writer.WriteHeader(http.StatusCreated)
+	}
 	if err := json.NewEncoder(writer).Encode(o); err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		a.Logger.Error().Err(err).Msg("failed to encode response")
+	}
+}
+
+// maxBatchBodySize leaves about 8 KiB for every link of a full batch.
+const maxBatchBodySize = link.MaxBatchSize * 8 << 10
+
+// shortenLinks shortens an array of links like shortenLink and returns results
+// in the same order. Failures of items don't fail the whole batch.
+func (a *Api) shortenLinks(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var models []shortenModel
+	body := http.MaxBytesReader(writer, request.Body, maxBatchBodySize)
+	if err := json.NewDecoder(body).Decode(&models); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	writer.WriteHeader(http.StatusCreated)
+	userId := GetUserId(a, request)
+
+	items := make([]link.BatchItem, len(models))
+	for i, m := range models {
+		items[i] = link.BatchItem{
+			RealLink:       m.Link,
+			ShortenOptions: m.options(),
+		}
+	}
+	results, err := a.LinkUseCases.ShortenLinks(userId, items)
+	if err != nil {
+		writer.WriteHeader(linkErrorStatus(err))
+		writer.Write([]byte(err.Error()))
+		return
+	}
+	if err := json.NewEncoder(writer).Encode(newBatchResultModels(results)); err != nil {
+		a.Logger.Error().Err(err).Msg("failed to encode response")
+	}
 }
 
-func (a *Api) deleteLink(writer http.ResponseWriter, request *http.Request) {
+func newBatchResultModels(results []link.BatchResult) []batchResultModel {
+	o := make([]batchResultModel, len(results))
+	for i, r := range results {
+		switch {
+		case r.Err != nil:
+			o[i] = batchResultModel{Error: r.Err.Error(), Status: linkErrorStatus(r.Err)}
+		case r.Reused:
+			o[i] = batchResultModel{Link: r.ShortLink, Reused: true, Status: http.StatusOK}
+		default:
+			o[i] = batchResultModel{Link: r.ShortLink, Status: http.StatusCreated}
+		}
+	}
+	return o
+}
+
+func (a *Api) setLinkExpiration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var m lifetimeModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	userId := r.Context().Value("account_id").(string)
+	key := mux.Vars(r)["key"]
+
+	expiresAt, err := a.LinkUseCases.SetLinkExpiration(key, userId, m.lifetime())
+	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	o := expirationModel{}
+	if !expiresAt.IsZero() {
+		o.ExpiresAt = &expiresAt
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) pauseLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("account_id").(string)
+	if err := a.LinkUseCases.PauseLink(mux.Vars(r)["key"], userId); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *Api) resumeLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("account_id").(string)
+	if err := a.LinkUseCases.ResumeLink(mux.Vars(r)["key"], userId); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// updateLink changes the destination of the link to the link of the body.
+func (a *Api) updateLink(w http.ResponseWriter, r *http.Request) {
 	var m linkModel
-	if err := json.NewDecoder(request.Body).Decode(&m); err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	userId := r.Context().Value("account_id").(string)
+	key := mux.Vars(r)["key"]
 
-	// get user id if exists
-	userId := GetUserId(a, request)
+	if err := a.LinkUseCases.UpdateLink(key, userId, m.Link); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	if _, err := a.LinkUseCases.DeleteLink(m.Link, userId); err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
+type passwordModel struct {
+	Password string `json:"password"`
+}
+
+func (a *Api) setLinkPassword(w http.ResponseWriter, r *http.Request) {
+	var m passwordModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil || m.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	userId := r.Context().Value("account_id").(string)
+	if err := a.LinkUseCases.SetLinkPassword(mux.Vars(r)["key"], userId, m.Password); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *Api) removeLinkPassword(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("account_id").(string)
+	if err := a.LinkUseCases.SetLinkPassword(mux.Vars(r)["key"], userId, ""); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type destinationChangeModel struct {
+	Link       string    `json:"link"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+// getLinkHistory returns previous destinations of the link from the latest one.
+func (a *Api) getLinkHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	userId := r.Context().Value("account_id").(string)
+	key := mux.Vars(r)["key"]
+
+	history, err := a.LinkUseCases.GetLinkHistory(key, userId)
+	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	o := make([]destinationChangeModel, 0, len(history))
+	for _, change := range history {
+		o = append(o, destinationChangeModel{Link: change.RealLink, ReplacedAt: change.ReplacedAt})
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) deleteLink(writer http.ResponseWriter, request *http.Request) {
+	userId := request.Context().Value("account_id").(string)
+	key := mux.Vars(request)["key"]
+
+	if _, err := a.LinkUseCases.DeleteLink(key, userId); err != nil {
+		writer.WriteHeader(linkErrorStatus(err))
 		return
 	}
 
 	writer.WriteHeader(http.StatusOK)
 }
 
+// getTrash lists deleted links which may be restored, from the latest deleted.
+func (a *Api) getTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	userId := r.Context().Value("account_id").(string)
+	links, err := a.LinkUseCases.GetTrash(userId)
+	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	o := make([]linkInfoModel, 0, len(links))
+	for _, info := range links {
+		o = append(o, newLinkInfoModel(info))
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		a.Logger.Error().Err(err).Msg("failed to encode response")
+	}
+}
+
+func (a *Api) restoreLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("account_id").(string)
+	if err := a.LinkUseCases.RestoreLink(mux.Vars(r)["key"], userId); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type linkInfoModel struct {
+	Key        string     `json:"key"`
+	Link       string     `json:"link"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	UseCounter uint64     `json:"useCounter"`
+	MaxClicks  uint64     `json:"maxClicks,omitempty"`
+	// Paused is set even when Status tells that the link doesn't work for another reason.
+	Paused bool `json:"paused"`
+	// DeletedAt is set for links in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Status is one of the link2.Status constants.
+	Status string `json:"status"`
+}
+
+func newLinkInfoModel(info link2.LinkInfo) linkInfoModel {
+	m := linkInfoModel{
+		Key:        info.Key,
+		Link:       info.RealLink,
+		CreatedAt:  info.CreatedAt,
+		UseCounter: info.UseCounter,
+		MaxClicks:  info.MaxClicks,
+		Paused:     info.Paused,
+		Status:     info.Status(time.Now()),
+	}
+	if !info.ActiveFrom.IsZero() {
+		m.ActiveFrom = &info.ActiveFrom
+	}
+	if !info.ExpiresAt.IsZero() {
+		m.ExpiresAt = &info.ExpiresAt
+	}
+	if !info.DeletedAt.IsZero() {
+		m.DeletedAt = &info.DeletedAt
+	}
+	return m
+}
+
+type linkPageModel struct {
+	Links      []linkInfoModel `json:"links"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// getUserLinks lists links of the account page by page. Query parameters:
+// sort=created|clicks, order=asc|desc (desc by default), q=destination substring,
+// cursor=nextCursor of the previous page, limit=page size.
 func (a *Api) getUserLinks(w http.ResponseWriter, r *http.Request) {
-	var links []string
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	userId := r.Context().Value("account_id").(string)
-	links, err := a.LinkUseCases.GetUserLinks(userId)
+	params := r.URL.Query()
+	query := link2.ListQuery{
+		SortBy:    params.Get("sort"),
+		Ascending: params.Get("order") == "asc",
+		Contains:  params.Get("q"),
+		Cursor:    params.Get("cursor"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := a.LinkUseCases.GetUserLinks(userId, query)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(linkErrorStatus(err))
 		return
 	}
-	if err := json.NewEncoder(w).Encode(links); err != nil {
+	o := linkPageModel{Links: make([]linkInfoModel, 0, len(page.Links)), NextCursor: page.NextCursor}
+	for _, info := range page.Links {
+		o.Links = append(o.Links, newLinkInfoModel(info))
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -236,17 +717,173 @@ func (a *Api) getUserLinkStats(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	o, err := a.LinkUseCases.GetLinkStats(m.Link)
+	userId := r.Context().Value("account_id").(string)
+	o, err := a.LinkUseCases.GetLinkStats(m.Link, userId)
 	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) getUserSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	userId := r.Context().Value("account_id").(string)
+	settings, err := a.LinkUseCases.GetUserSettings(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	o := settingsModel{
+		keyPolicyModel: keyPolicyModel{Alphabet: settings.KeyPolicy.Alphabet, KeyLength: settings.KeyPolicy.Length},
+		MaxTTL:         int64(settings.MaxTTL / time.Second),
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) setUserSettings(w http.ResponseWriter, r *http.Request) {
+	var m keyPolicyModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	userId := r.Context().Value("account_id").(string)
+	if err := a.LinkUseCases.SetUserKeyPolicy(userId, m.keyPolicy()); err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type bucketModel struct {
+	Start    time.Time `json:"start"`
+	Clicks   uint64    `json:"clicks"`
+	Visitors uint64    `json:"uniqueVisitors"`
+}
+
+type timeSeriesModel struct {
+	Key      string        `json:"key"`
+	Interval string        `json:"interval"`
+	TimeZone string        `json:"tz"`
+	Total    uint64        `json:"total"`
+	Visitors uint64        `json:"uniqueVisitors"`
+	Buckets  []bucketModel `json:"buckets"`
+}
+
+// getLinkTimeSeries returns clicks of the link bucketed over time. Query parameters:
+// from, to (RFC 3339 or 2006-01-02), interval=hour|day|week, tz=IANA time zone,
+// bots=exclude|include|only.
+func (a *Api) getLinkTimeSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	userId := r.Context().Value("account_id").(string)
+	key := mux.Vars(r)["key"]
+	params := r.URL.Query()
+	query := link.TimeSeriesQuery{
+		Interval: params.Get("interval"),
+		TimeZone: params.Get("tz"),
+		Bots:     params.Get("bots"),
+	}
+	var err error
+	if query.From, err = parseStatsTime(params.Get("from"), query.TimeZone); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if query.To, err = parseStatsTime(params.Get("to"), query.TimeZone); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ts, err := a.LinkUseCases.GetLinkTimeSeries(key, userId, query)
+	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	o := timeSeriesModel{
+		Key:      ts.Key,
+		Interval: ts.Interval,
+		TimeZone: ts.Location.String(),
+		Total:    ts.Total,
+		Visitors: ts.Visitors,
+		Buckets:  make([]bucketModel, 0, len(ts.Buckets)),
+	}
+	for _, b := range ts.Buckets {
+		o.Buckets = append(o.Buckets, bucketModel{Start: b.Start, Clicks: b.Clicks, Visitors: b.Visitors})
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+type valueCountModel struct {
+	Value  string `json:"value"`
+	Clicks uint64 `json:"clicks"`
+}
+
+// getLinkBreakdown returns the most popular values of a click property. Query parameters:
+// by=browser|os|device|referrer|country|region|city, limit, from, to (RFC 3339 or 2006-01-02 in UTC),
+// bots=exclude|include|only. Empty referrer value stands for direct visits.
+func (a *Api) getLinkBreakdown(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	userId := r.Context().Value("account_id").(string)
+	key := mux.Vars(r)["key"]
+	params := r.URL.Query()
+	query := link.BreakdownQuery{Dimension: params.Get("by"), Bots: params.Get("bots")}
+	var err error
+	if query.From, err = parseStatsTime(params.Get("from"), ""); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if query.To, err = parseStatsTime(params.Get("to"), ""); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	values, err := a.LinkUseCases.GetLinkBreakdown(key, userId, query)
+	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	o := make([]valueCountModel, 0, len(values))
+	for _, v := range values {
+		o = append(o, valueCountModel{Value: v.Value, Clicks: v.Clicks})
+	}
 	if err := json.NewEncoder(w).Encode(o); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
+// parseStatsTime accepts RFC 3339 time or a date, which starts in the time zone tz.
+func parseStatsTime(s string, tz string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
+
 type responseWriterObserver struct {
 	http.ResponseWriter
 	status int
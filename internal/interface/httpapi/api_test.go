@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	link2 "koro.che/internal/domain/link"
 	"koro.che/internal/usecases/account"
+	"koro.che/internal/usecases/link"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 type AccountUseCasesFake struct{}
@@ -35,15 +40,189 @@ func (AccountUseCasesFake) LoginToAccount(login, password string) (string, error
 }
 
 func (a *AccountUseCasesFake) Authenticate(token string) (string, error) {
-	panic("implement me")
+	if token == "token" {
+		return "1", nil
+	}
+	return "", errors.New("invalid token")
 }
 
 func (a AccountUseCasesFake) Logout() {
 	panic("implement me")
 }
 
+type LinkUseCasesFake struct{}
+
+func (LinkUseCasesFake) ShortenLink(realLink string, userId string, opts link.ShortenOptions) (link.ShortenResult, error) {
+	switch opts.Alias {
+	case "":
+		return link.ShortenResult{ShortLink: "localhost:8080/abcdef", Reused: opts.ReuseExisting}, nil
+	case "taken":
+		return link.ShortenResult{}, link2.ErrAliasTaken
+	case "api":
+		return link.ShortenResult{}, link.ErrReservedAlias
+	default:
+		return link.ShortenResult{ShortLink: "localhost:8080/" + opts.Alias}, nil
+	}
+}
+
+func (f LinkUseCasesFake) ShortenLinks(userId string, items []link.BatchItem) ([]link.BatchResult, error) {
+	if len(items) > link.MaxBatchSize {
+		return nil, link.ErrBatchTooLarge
+	}
+	results := make([]link.BatchResult, len(items))
+	for i, item := range items {
+		results[i].ShortenResult, results[i].Err = f.ShortenLink(item.RealLink, userId, item.ShortenOptions)
+	}
+	return results, nil
+}
+
+func (LinkUseCasesFake) ExportLinks(userId string, fn func(info link2.LinkInfo) error) error {
+	created := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	links := []link2.LinkInfo{
+		{Key: "sale", RealLink: "example.com/sale", CreatedAt: created, UseCounter: 3, MaxClicks: 10, PasswordHash: "$2a$10$hash"},
+		{Key: "news", RealLink: "example.com/news, 2021", CreatedAt: created, ActiveFrom: created.Add(time.Minute), ExpiresAt: created.Add(time.Hour)},
+	}
+	for _, info := range links {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (LinkUseCasesFake) ImportLinks(userId string, links []link2.LinkInfo) ([]link.BatchResult, error) {
+	results := make([]link.BatchResult, len(links))
+	for i, info := range links {
+		if info.Key == "taken" {
+			results[i].Err = link2.ErrAliasTaken
+			continue
+		}
+		results[i].ShortLink = "localhost:8080/" + info.Key
+	}
+	return results, nil
+}
+
+func (LinkUseCasesFake) UpdateLink(key string, userId string, realLink string) error {
+	switch {
+	case realLink == "":
+		return link.ErrEmptyDestination
+	case key == "foreign":
+		return link2.ErrForbidden
+	}
+	return nil
+}
+
+func (LinkUseCasesFake) GetLinkHistory(key string, userId string) ([]link2.DestinationChange, error) {
+	return []link2.DestinationChange{{RealLink: "example.com/old", ReplacedAt: time.Now()}}, nil
+}
+
+func (LinkUseCasesFake) MakeRedirect(key string, visit link.Visit) (string, error) {
+	switch key {
+	case "alive":
+		return "example.com", nil
+	case "expired":
+		return "", link2.ErrExpired
+	case "exhausted":
+		return "", link2.ErrExhausted
+	case "scheduled":
+		return "", link2.ErrNotActive
+	case "paused":
+		return "", link2.ErrPaused
+	case "limited":
+		return "", link.ErrBotOfLimitedLink
+	case "secret":
+		switch visit.Password {
+		case "":
+			return "", link.ErrPasswordRequired
+		case "open sesame":
+			return "example.com", nil
+		}
+		return "", link.ErrWrongPassword
+	default:
+		return "", link2.ErrNotExist
+	}
+}
+
+func (LinkUseCasesFake) GetTrash(userId string) ([]link2.LinkInfo, error) {
+	return []link2.LinkInfo{{Key: "sale", RealLink: "example.com", DeletedAt: time.Now()}}, nil
+}
+
+func (LinkUseCasesFake) RestoreLink(key string, userId string) error {
+	switch key {
+	case "foreign":
+		return link2.ErrForbidden
+	case "purged":
+		return link2.ErrNotExist
+	}
+	return nil
+}
+
+func (LinkUseCasesFake) PauseLink(key string, userId string) error {
+	if key == "foreign" {
+		return link2.ErrForbidden
+	}
+	return nil
+}
+
+func (LinkUseCasesFake) ResumeLink(key string, userId string) error {
+	if key == "foreign" {
+		return link2.ErrForbidden
+	}
+	return nil
+}
+
+func (LinkUseCasesFake) SetLinkPassword(key string, userId string, password string) error {
+	if password != "" && len(password) < 4 {
+		return link.ErrInvalidLinkPassword
+	}
+	return nil
+}
+
+func (LinkUseCasesFake) DeleteLink(link string, userId string) (string, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) GetRealLink(key string, password string) (string, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) GetUserLinks(userId string, query link2.ListQuery) (link2.LinkPage, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) GetLinkStats(key string, userId string) (link.LinkStat, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) GetLinkTimeSeries(key string, userId string, query link.TimeSeriesQuery) (link.TimeSeries, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) GetLinkBreakdown(key string, userId string, query link.BreakdownQuery) ([]link2.ValueCount, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) SetLinkExpiration(key string, userId string, lifetime link.Lifetime) (time.Time, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) CreateUserLinksStorage(userId string) (string, error) {
+	return "", nil
+}
+
+func (LinkUseCasesFake) GetUserSettings(userId string) (link.UserSettings, error) {
+	panic("implement me")
+}
+
+func (LinkUseCasesFake) SetUserKeyPolicy(userId string, policy link.KeyPolicy) error {
+	if policy.Alphabet == "emoji" {
+		return link.ErrUnknownAlphabet
+	}
+	return nil
+}
+
 func Test_postSignup(t *testing.T) {
-	service := NewApi(&AccountUseCasesFake{}, nil)
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
 	router := service.Router()
 
 	t.Run("failure on invalid json", func(t *testing.T) {
@@ -132,6 +311,429 @@ func Test_postSignin(t *testing.T) {
 	})
 }
 
+func Test_postShorten(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	t.Run("failure on invalid json", func(t *testing.T) {
+		resp := invalidJsonTest(router, "/api/shorten")
+		assertStatusCode(t, resp.Code, http.StatusBadRequest)
+	})
+	t.Run("successful shortening with alias", func(t *testing.T) {
+		resp := shortenTest(t, router, shortenModel{Link: "example.com", Alias: "spring-sale"})
+		assertStatusCode(t, resp.Code, http.StatusCreated)
+
+		var o shortenResultModel
+		if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+			t.Fatal("failed to decode response")
+		}
+		if o.Link != "localhost:8080/spring-sale" {
+			t.Errorf("Server MUST return %s link, but %s given", "localhost:8080/spring-sale", o.Link)
+		}
+	})
+	t.Run("reused link", func(t *testing.T) {
+		resp := shortenTest(t, router, shortenModel{Link: "example.com", Reuse: true})
+		assertStatusCode(t, resp.Code, http.StatusOK)
+
+		var o shortenResultModel
+		if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+			t.Fatal("failed to decode response")
+		}
+		if !o.Reused {
+			t.Errorf("Server MUST tell that the link is reused")
+		}
+	})
+	t.Run("taken alias", func(t *testing.T) {
+		resp := shortenTest(t, router, shortenModel{Link: "example.com", Alias: "taken"})
+		assertStatusCode(t, resp.Code, http.StatusConflict)
+	})
+	t.Run("reserved alias", func(t *testing.T) {
+		resp := shortenTest(t, router, shortenModel{Link: "example.com", Alias: "api"})
+		assertStatusCode(t, resp.Code, http.StatusBadRequest)
+	})
+}
+
+func Test_postShortenBatch(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	t.Run("per item results", func(t *testing.T) {
+		resp := shortenBatchTest(t, router, []shortenModel{
+			{Link: "example.com", Alias: "spring-sale"},
+			{Link: "example.com", Alias: "taken"},
+		})
+		assertStatusCode(t, resp.Code, http.StatusOK)
+
+		var o []batchResultModel
+		if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+			t.Fatal("failed to decode response")
+		}
+		if len(o) != 2 || o[0].Status != http.StatusCreated || o[0].Link != "localhost:8080/spring-sale" {
+			t.Fatalf("First item MUST be created, but %+v given", o)
+		}
+		if o[1].Status != http.StatusConflict || o[1].Error == "" {
+			t.Errorf("Second item MUST fail with conflict, but %+v given", o[1])
+		}
+	})
+	t.Run("too large batch", func(t *testing.T) {
+		resp := shortenBatchTest(t, router, make([]shortenModel, link.MaxBatchSize+1))
+		assertStatusCode(t, resp.Code, http.StatusRequestEntityTooLarge)
+	})
+	t.Run("too large body", func(t *testing.T) {
+		huge := strings.Repeat("a", maxBatchBodySize)
+		resp := shortenBatchTest(t, router, []shortenModel{{Link: "example.com/" + huge}})
+		assertStatusCode(t, resp.Code, http.StatusBadRequest)
+	})
+}
+
+func Test_exportLinks(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	t.Run("csv", func(t *testing.T) {
+		resp := authorizedTest(router, http.MethodGet, "/api/manage/export?format=csv", nil)
+		assertStatusCode(t, resp.Code, http.StatusOK)
+		expected := "key,link,created_at,expires_at,use_counter,max_clicks,active_from,password_protected\n" +
+			"sale,example.com/sale,2021-05-01T10:00:00Z,,3,10,,true\n" +
+			"news,\"example.com/news, 2021\",2021-05-01T10:00:00Z,2021-05-01T11:00:00Z,0,0,2021-05-01T10:01:00Z,false\n"
+		if actual := resp.Body.String(); actual != expected {
+			t.Errorf("Export MUST be\n%s but\n%s given", expected, actual)
+		}
+		links, err := parseCSVLinks(strings.NewReader(expected))
+		if err != nil || len(links) != 2 || links[0].MaxClicks != 10 || links[1].MaxClicks != 0 {
+			t.Errorf("Import MUST keep the click limits, but %+v (%v) given", links, err)
+		}
+		if !links[0].ActiveFrom.IsZero() || !links[1].ActiveFrom.Equal(time.Date(2021, 5, 1, 10, 1, 0, 0, time.UTC)) {
+			t.Errorf("Import MUST keep the start times, but %+v given", links)
+		}
+		if !links[0].protected || links[1].protected || links[0].PasswordHash != "" {
+			t.Errorf("Import MUST keep the protection marks, but %+v given", links)
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		resp := authorizedTest(router, http.MethodGet, "/api/manage/export", nil)
+		assertStatusCode(t, resp.Code, http.StatusOK)
+		links, err := parseJSONLinks(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(links) != 2 || links[0].Key != "sale" || links[1].ExpiresAt.IsZero() {
+			t.Errorf("Export MUST have both links, but %+v given", links)
+		}
+		if !links[0].protected || links[1].protected || links[0].PasswordHash != "" {
+			t.Errorf("Export MUST mark protected links without their hashes, but %+v given", links)
+		}
+	})
+	t.Run("unknown format", func(t *testing.T) {
+		resp := authorizedTest(router, http.MethodGet, "/api/manage/export?format=xml", nil)
+		assertStatusCode(t, resp.Code, http.StatusBadRequest)
+	})
+}
+
+func Test_importLinks(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	body := "link,key,use_counter\nexample.com/sale,sale,3\nexample.com/news,taken,\n"
+	resp := authorizedTest(router, http.MethodPost, "/api/manage/import?format=csv", []byte(body))
+	assertStatusCode(t, resp.Code, http.StatusOK)
+	var o []batchResultModel
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(o) != 2 || o[0].Link != "localhost:8080/sale" || o[1].Status != http.StatusConflict {
+		t.Errorf("Import MUST create the first link and report conflict of the second, but %+v given", o)
+	}
+
+	body = "link,key,password_protected,password_hash\n" +
+		"example.com/secret,secret,true,\n" +
+		"example.com/hashed,hashed,true,$2a$10$hash\n" +
+		"example.com/news,taken,false,\n"
+	resp = authorizedTest(router, http.MethodPost, "/api/manage/import?format=csv", []byte(body))
+	assertStatusCode(t, resp.Code, http.StatusOK)
+	o = nil
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(o) != 3 || o[0].Status != http.StatusBadRequest || o[1].Link != "localhost:8080/hashed" || o[2].Status != http.StatusConflict {
+		t.Errorf("Protected link without its hash MUST fail alone, but %+v given", o)
+	}
+
+	resp = authorizedTest(router, http.MethodPost, "/api/manage/import?format=csv", []byte("url\nexample.com\n"))
+	assertStatusCode(t, resp.Code, http.StatusBadRequest)
+
+	huge := "key,link\nhuge,example.com/" + strings.Repeat("a", maxImportBodySize) + "\n"
+	resp = authorizedTest(router, http.MethodPost, "/api/manage/import?format=csv", []byte(huge))
+	assertStatusCode(t, resp.Code, http.StatusBadRequest)
+}
+
+func Test_setUserSettings(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	resp := authorizedTest(router, http.MethodPut, "/api/manage/settings", []byte(`{}`))
+	assertStatusCode(t, resp.Code, http.StatusNoContent)
+	resp = authorizedTest(router, http.MethodPut, "/api/manage/settings", []byte(`{"alphabet":"emoji"}`))
+	assertStatusCode(t, resp.Code, http.StatusBadRequest)
+}
+
+func Test_patchLink(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	cases := []struct {
+		name   string
+		key    string
+		body   string
+		status int
+	}{
+		{"new destination", "sale", `{"link": "example.com/new"}`, http.StatusOK},
+		{"empty destination", "sale", `{"link": ""}`, http.StatusBadRequest},
+		{"foreign link", "foreign", `{"link": "example.com/new"}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := authorizedTest(router, http.MethodPatch, "/api/manage/"+c.key, []byte(c.body))
+			assertStatusCode(t, resp.Code, c.status)
+		})
+	}
+}
+
+func Test_getRedirect(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	t.Run("redirect to alive link", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/alive", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusFound)
+		if cc := resp.Header().Get("Cache-Control"); cc != "private, max-age=0" {
+			t.Errorf("Redirect MUST NOT be cached, but Cache-Control %q given", cc)
+		}
+	})
+	t.Run("limited link is not shown to bots", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusNoContent)
+		if location := resp.Header().Get("Location"); location != "" {
+			t.Errorf("Bots MUST NOT get the link, but Location %q given", location)
+		}
+	})
+	t.Run("unknown link", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusNotFound)
+	})
+	t.Run("expired link is gone", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expired", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusGone)
+	})
+	t.Run("exhausted link is gone", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/exhausted", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusGone)
+	})
+}
+
+func Test_notActiveRedirect(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/scheduled", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assertStatusCode(t, resp.Code, http.StatusNotFound)
+
+	service.NotActive = StatusPage{Status: http.StatusServiceUnavailable, Page: []byte("<p>Coming soon</p>")}
+	req = httptest.NewRequest(http.MethodGet, "/scheduled", nil)
+	req.Header.Set("Accept", "text/html")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assertStatusCode(t, resp.Code, http.StatusServiceUnavailable)
+	if resp.Body.String() != "<p>Coming soon</p>" {
+		t.Errorf("Configured page MUST be shown, but %q given", resp.Body.String())
+	}
+}
+
+func Test_trash(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	resp := authorizedTest(router, http.MethodGet, "/api/manage/trash", nil)
+	assertStatusCode(t, resp.Code, http.StatusOK)
+	var links []linkInfoModel
+	if err := json.NewDecoder(resp.Body).Decode(&links); err != nil || len(links) != 1 || links[0].Status != link2.StatusDeleted {
+		t.Errorf("Trash MUST list deleted links, but %+v (%v) given", links, err)
+	}
+
+	cases := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"restore", "sale", http.StatusOK},
+		{"restore foreign link", "foreign", http.StatusForbidden},
+		{"restore purged link", "purged", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := authorizedTest(router, http.MethodPost, "/api/manage/"+c.key+"/restore", nil)
+			assertStatusCode(t, resp.Code, c.status)
+		})
+	}
+}
+
+func Test_pauseLink(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"pause", "/api/manage/sale/pause", http.StatusOK},
+		{"resume", "/api/manage/sale/resume", http.StatusOK},
+		{"pause foreign link", "/api/manage/foreign/pause", http.StatusForbidden},
+		{"resume foreign link", "/api/manage/foreign/resume", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := authorizedTest(router, http.MethodPost, c.path, nil)
+			assertStatusCode(t, resp.Code, c.status)
+		})
+	}
+
+	t.Run("paused link is unavailable", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/paused", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusServiceUnavailable)
+	})
+}
+
+func Test_protectedRedirect(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	t.Run("password is required", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusUnauthorized)
+	})
+	t.Run("browser gets unlock form", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusUnauthorized)
+		if !strings.Contains(resp.Body.String(), `action="/secret"`) {
+			t.Errorf("no unlock form in %q", resp.Body.String())
+		}
+	})
+	t.Run("password in header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+		req.Header.Set(linkPasswordHeader, "open sesame")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusFound)
+		if cc := resp.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Unlocked redirect MUST NOT be stored, but Cache-Control %q given", cc)
+		}
+	})
+	t.Run("wrong password in header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+		req.Header.Set(linkPasswordHeader, "guess")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusForbidden)
+	})
+	t.Run("password in form", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader("password=open+sesame"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, resp.Code, http.StatusSeeOther)
+		if cc := resp.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Unlocked redirect MUST NOT be stored, but Cache-Control %q given", cc)
+		}
+	})
+}
+
+func Test_setLinkPassword(t *testing.T) {
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	router := service.Router()
+
+	resp := authorizedTest(router, http.MethodPut, "/api/manage/sale/password", []byte(`{"password": "open sesame"}`))
+	assertStatusCode(t, resp.Code, http.StatusOK)
+
+	resp = authorizedTest(router, http.MethodPut, "/api/manage/sale/password", []byte(`{"password": "abc"}`))
+	assertStatusCode(t, resp.Code, http.StatusBadRequest)
+
+	resp = authorizedTest(router, http.MethodDelete, "/api/manage/sale/password", nil)
+	assertStatusCode(t, resp.Code, http.StatusOK)
+}
+
+func Test_clientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	service := NewApi(&AccountUseCasesFake{}, &LinkUseCasesFake{})
+	service.TrustedProxies = []*net.IPNet{proxies}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:1234", "", "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"spoofed header behind proxy", "10.0.0.1:1234", "192.0.2.66, 198.51.100.1", "198.51.100.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/key", nil)
+			req.RemoteAddr = c.remoteAddr
+			if c.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", c.forwarded)
+			}
+			if actual := service.clientIP(req); actual != c.expected {
+				t.Errorf("Client address MUST be %s, but %s given", c.expected, actual)
+			}
+		})
+	}
+}
+
 func assertStatusCode(t *testing.T, expectedCode, actualCode int) {
 	if expectedCode != actualCode {
 		t.Errorf("Server MUST return %d (%s) status code, but %d (%s) given",
@@ -139,6 +741,36 @@ func assertStatusCode(t *testing.T, expectedCode, actualCode int) {
 	}
 }
 
+func shortenTest(t *testing.T, router http.Handler, m shortenModel) *httptest.ResponseRecorder {
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal("failed to marshal struct")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(b))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func shortenBatchTest(t *testing.T, router http.Handler, m []shortenModel) *httptest.ResponseRecorder {
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal("failed to marshal struct")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader(b))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func authorizedTest(router http.Handler, method string, path string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: "token", Value: "token"})
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
 func invalidJsonTest(router http.Handler, path string) *httptest.ResponseRecorder {
 	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte("{a:")))
 	resp := httptest.NewRecorder()
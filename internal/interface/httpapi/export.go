@@ -0,0 +1,253 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	link2 "koro.che/internal/domain/link"
+	"koro.che/internal/usecases/link"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+var (
+	errInvalidCSV          = errors.New("invalid CSV of links")
+	errPasswordNotExported = errors.New("password of the link is not exported, import it without the protected mark and protect it again")
+)
+
+// maxImportBodySize leaves about 4 KiB for every link of a full import.
+const maxImportBodySize = link.MaxImportSize * 4 << 10
+
+// csvHeader lists the columns of exported links, imported files may have them
+// in any order. Imported files may also have a password_hash column.
+var csvHeader = []string{"key", "link", "created_at", "expires_at", "use_counter", "max_clicks", "active_from", "password_protected"}
+
+// exportLinkModel is a link of an export. Password hashes are not exported, a
+// leaked export would let anyone guess short passwords offline. Protected
+// links are marked instead, so an import doesn't make them public, and the
+// import takes a bcrypt hash of the password.
+type exportLinkModel struct {
+	linkInfoModel
+	PasswordProtected bool   `json:"passwordProtected,omitempty"`
+	PasswordHash      string `json:"passwordHash,omitempty"`
+}
+
+// importedLink is a link of an imported file.
+type importedLink struct {
+	link2.LinkInfo
+	protected bool
+}
+
+// exportLinks streams all the links of the account. Query parameters: format=json|csv.
+func (a *Api) exportLinks(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value("account_id").(string)
+	var err error
+	switch r.URL.Query().Get("format") {
+	case "", formatJSON:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = a.exportJSON(w, userId)
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="links.csv"`)
+		err = a.exportCSV(w, userId)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// the response has already started, so the client gets a broken file
+		a.Logger.Error().Err(err).Str("account", userId).Msg("failed to export links")
+	}
+}
+
+func (a *Api) exportJSON(w io.Writer, userId string) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	first := true
+	err := a.LinkUseCases.ExportLinks(userId, func(info link2.LinkInfo) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		b, err := json.Marshal(exportLinkModel{linkInfoModel: newLinkInfoModel(info), PasswordProtected: info.PasswordHash != ""})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+func (a *Api) exportCSV(w io.Writer, userId string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	err := a.LinkUseCases.ExportLinks(userId, func(info link2.LinkInfo) error {
+		var expiresAt, activeFrom string
+		if !info.ExpiresAt.IsZero() {
+			expiresAt = info.ExpiresAt.Format(time.RFC3339)
+		}
+		if !info.ActiveFrom.IsZero() {
+			activeFrom = info.ActiveFrom.Format(time.RFC3339)
+		}
+		return cw.Write([]string{
+			info.Key,
+			info.RealLink,
+			info.CreatedAt.Format(time.RFC3339),
+			expiresAt,
+			strconv.FormatUint(info.UseCounter, 10),
+			strconv.FormatUint(info.MaxClicks, 10),
+			activeFrom,
+			strconv.FormatBool(info.PasswordHash != ""),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// importLinks recreates links from an export of the same format. Links keep
+// their keys where they are free, the results are in the order of the links.
+// Protected links without a password hash fail rather than become public.
+func (a *Api) importLinks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	userId := r.Context().Value("account_id").(string)
+	var imported []importedLink
+	var err error
+	body := http.MaxBytesReader(w, r.Body, maxImportBodySize)
+	switch r.URL.Query().Get("format") {
+	case "", formatJSON:
+		imported, err = parseJSONLinks(body)
+	case formatCSV:
+		imported, err = parseCSVLinks(body)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var links []link2.LinkInfo
+	for _, l := range imported {
+		if !l.protected || l.PasswordHash != "" {
+			links = append(links, l.LinkInfo)
+		}
+	}
+	stored, err := a.LinkUseCases.ImportLinks(userId, links)
+	if err != nil {
+		w.WriteHeader(linkErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	results := make([]link.BatchResult, len(imported))
+	for i, l := range imported {
+		if l.protected && l.PasswordHash == "" {
+			results[i].Err = errPasswordNotExported
+			continue
+		}
+		results[i], stored = stored[0], stored[1:]
+	}
+	if err := json.NewEncoder(w).Encode(newBatchResultModels(results)); err != nil {
+		a.Logger.Error().Err(err).Msg("failed to encode response")
+	}
+}
+
+func parseJSONLinks(r io.Reader) ([]importedLink, error) {
+	var models []exportLinkModel
+	if err := json.NewDecoder(r).Decode(&models); err != nil {
+		return nil, err
+	}
+	links := make([]importedLink, len(models))
+	for i, m := range models {
+		links[i] = importedLink{
+			LinkInfo:  link2.LinkInfo{Key: m.Key, RealLink: m.Link, UseCounter: m.UseCounter, MaxClicks: m.MaxClicks, PasswordHash: m.PasswordHash},
+			protected: m.PasswordProtected,
+		}
+		if m.ActiveFrom != nil {
+			links[i].ActiveFrom = *m.ActiveFrom
+		}
+		if m.ExpiresAt != nil {
+			links[i].ExpiresAt = *m.ExpiresAt
+		}
+	}
+	return links, nil
+}
+
+func parseCSVLinks(r io.Reader) ([]importedLink, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, errInvalidCSV
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	if _, ok := columns["key"]; !ok {
+		return nil, errInvalidCSV
+	}
+	if _, ok := columns["link"]; !ok {
+		return nil, errInvalidCSV
+	}
+	var links []importedLink
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return links, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		info := importedLink{LinkInfo: link2.LinkInfo{Key: record[columns["key"]], RealLink: record[columns["link"]]}}
+		if i, ok := columns["password_hash"]; ok {
+			info.PasswordHash = record[i]
+		}
+		if i, ok := columns["password_protected"]; ok && record[i] != "" {
+			if info.protected, err = strconv.ParseBool(record[i]); err != nil {
+				return nil, err
+			}
+		}
+		if i, ok := columns["expires_at"]; ok && record[i] != "" {
+			if info.ExpiresAt, err = time.Parse(time.RFC3339, record[i]); err != nil {
+				return nil, err
+			}
+		}
+		if i, ok := columns["use_counter"]; ok && record[i] != "" {
+			if info.UseCounter, err = strconv.ParseUint(record[i], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		if i, ok := columns["active_from"]; ok && record[i] != "" {
+			if info.ActiveFrom, err = time.Parse(time.RFC3339, record[i]); err != nil {
+				return nil, err
+			}
+		}
+		if i, ok := columns["max_clicks"]; ok && record[i] != "" {
+			if info.MaxClicks, err = strconv.ParseUint(record[i], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		links = append(links, info)
+	}
+}
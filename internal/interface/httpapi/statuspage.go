@@ -0,0 +1,23 @@
+package httpapi
+
+import "net/http"
+
+// StatusPage is the configurable answer to redirects of links which don't
+// work for a while, e.g. scheduled or paused ones.
+type StatusPage struct {
+	Status int
+	// Page is the HTML shown to browsers, others get the status only.
+	Page []byte
+}
+
+func (p StatusPage) write(w http.ResponseWriter, r *http.Request) {
+	if len(p.Page) == 0 || !wantsHTML(r) {
+		w.WriteHeader(p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(p.Status)
+	if r.Method != http.MethodHead {
+		w.Write(p.Page)
+	}
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"html/template"
+	"koro.che/internal/usecases/link"
+	"net/http"
+	"strings"
+)
+
+// linkPasswordHeader passes the password of a protected link by API clients.
+const linkPasswordHeader = "X-Link-Password"
+
+var unlockPage = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Protected link</title>
+</head>
+<body>
+<form method="post" action="/{{.Key}}">
+<p>This link is protected with a password.</p>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// linkPassword returns the password sent with the unlock form or in the header.
+func linkPassword(r *http.Request) string {
+	if r.Method == http.MethodPost {
+		return r.PostFormValue("password")
+	}
+	return r.Header.Get(linkPasswordHeader)
+}
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// isLocked tells errors of protected links which a browser may fix with the unlock form.
+func isLocked(err error) bool {
+	return err == link.ErrPasswordRequired || err == link.ErrWrongPassword || err == link.ErrTooManyAttempts
+}
+
+func (a *Api) writeUnlockPage(w http.ResponseWriter, key string, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(linkErrorStatus(err))
+	data := struct {
+		Key   string
+		Error string
+	}{Key: key}
+	if err != link.ErrPasswordRequired {
+		data.Error = err.Error()
+	}
+	if err := unlockPage.Execute(w, data); err != nil {
+		a.Logger.Error().Err(err).Msg("failed to render unlock page")
+	}
+}
@@ -2,123 +2,404 @@ package linkrepo
 
 import (
 	link2 "koro.che/internal/domain/link"
-	"math/rand"
+	"koro.che/internal/hll"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+type record struct {
+	creatorId    string
+	realLink     string
+	createdAt    time.Time
+	activeFrom   time.Time
+	expiresAt    time.Time
+	useCounter   uint64
+	passwordHash string
+	maxClicks    uint64
+	paused       bool
+	deletedAt    time.Time
+	// purged links keep only their keys
+	purged bool
+	// history has previous destinations from the oldest one.
+	history []link2.DestinationChange
+}
+
+func (r *record) info(key string) link2.LinkInfo {
+	return link2.LinkInfo{
+		Key:          key,
+		RealLink:     r.realLink,
+		CreatedAt:    r.createdAt,
+		ActiveFrom:   r.activeFrom,
+		ExpiresAt:    r.expiresAt,
+		UseCounter:   r.useCounter,
+		PasswordHash: r.passwordHash,
+		MaxClicks:    r.maxClicks,
+		Paused:       r.paused,
+		DeletedAt:    r.deletedAt,
+	}
+}
+
 type Memory struct {
-	linkByKey  map[string]string
-	StatsByKey map[string]uint64
+	linkByKey       map[string]*record
 	userToLinksKeys map[string]map[string]bool
-	mu         *sync.Mutex
+	settingsByUser  map[string]link2.Settings
+	clicksByKey     map[string][]link2.Click
+	sketchesByKey   map[string]map[int64]*hll.Sketch
+	nextKeyId       uint64
+	now             func() time.Time
+	mu              *sync.Mutex
 }
 
 func NewMemory() *Memory {
 	return &Memory{
-		linkByKey:  make(map[string]string),
-		StatsByKey: make(map[string]uint64),
+		linkByKey:       make(map[string]*record),
 		userToLinksKeys: make(map[string]map[string]bool),
-		mu:         &sync.Mutex{},
+		settingsByUser:  make(map[string]link2.Settings),
+		clicksByKey:     make(map[string][]link2.Click),
+		sketchesByKey:   make(map[string]map[int64]*hll.Sketch),
+		nextKeyId:       1,
+		now:             time.Now,
+		mu:              &sync.Mutex{},
 	}
 }
 
-const allowedLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-const linkSize = 6
+// WithClock makes the storage tell the time by now, so tests can move it.
+func (m *Memory) WithClock(now func() time.Time) *Memory {
+	m.now = now
+	return m
+}
 
-func RandString() string {
-	b := make([]byte, linkSize)
-	for i := range b {
-		b[i] = allowedLetters[rand.Intn(len(allowedLetters))]
+func (m *Memory) GetLinkByKey(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getAliveLink(key)
+	if err != nil {
+		return "", err
 	}
-	return string(b)
+	return r.realLink, nil
 }
 
-func (m *Memory) CreateShortLink(link string, userId string) (string, error) {
+func (m *Memory) GetLinkInfo(key string) (link2.LinkInfo, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var shortLink string
-	for {
-		shortLink = RandString()
-		if m.linkByKey[shortLink] == "" {
-			m.linkByKey[shortLink] = link
-			m.StatsByKey[shortLink] = 0
-			break
+	r, err := m.getAliveLink(key)
+	if err != nil {
+		return link2.LinkInfo{}, err
+	}
+	return r.info(key), nil
+}
+
+func (m *Memory) CreateShortLinks(userId string, links []link2.NewLink) ([]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	errs := make([]error, len(links))
+	for i, l := range links {
+		if _, ok := m.linkByKey[l.Key]; ok {
+			errs[i] = link2.ErrAliasTaken
+			continue
+		}
+		m.linkByKey[l.Key] = &record{
+			creatorId:    userId,
+			realLink:     l.RealLink,
+			createdAt:    m.now(),
+			activeFrom:   l.ActiveFrom,
+			expiresAt:    l.ExpiresAt,
+			useCounter:   l.UseCounter,
+			maxClicks:    l.MaxClicks,
+			passwordHash: l.PasswordHash,
+		}
+		if userId != "" {
+			m.userToLinksKeys[userId][l.Key] = true
 		}
 	}
-	if userId != "" {
-		m.userToLinksKeys[userId][shortLink] = true
+	return errs, nil
+}
+
+func (m *Memory) FindUserLink(userId string, realLink string) (link2.LinkInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	destination := link2.NormalizeDestination(realLink)
+	now := m.now()
+	var found link2.LinkInfo
+	for key, r := range m.linkByKey {
+		if r.creatorId != userId || r.info(key).Status(now) != link2.StatusActive || link2.NormalizeDestination(r.realLink) != destination {
+			continue
+		}
+		if r.passwordHash != "" || r.maxClicks != 0 || !r.activeFrom.IsZero() {
+			continue
+		}
+		if found.Key == "" || r.createdAt.After(found.CreatedAt) {
+			found = r.info(key)
+		}
 	}
-	return shortLink, nil
+	if found.Key == "" {
+		return link2.LinkInfo{}, link2.ErrNotExist
+	}
+	return found, nil
 }
 
-func (m *Memory) GetLinkByKey(key string) (string, error) {
+func (m *Memory) ReserveKeyIds() (uint64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var link = m.linkByKey[key]
-	var err error = nil
-	if link == "" {
-		err = link2.ErrNotExist
+	first := m.nextKeyId
+	m.nextKeyId += link2.KeyIdBlockSize
+	return first, nil
+}
+
+func (m *Memory) ForEachKey(fn func(key string) error) error {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.linkByKey))
+	for key, r := range m.linkByKey {
+		if r.deletedAt.IsZero() {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.Unlock()
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
 	}
-	return link, err
+	return nil
 }
 
 func (m *Memory) MakeRedirect(key string) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var link = m.linkByKey[key]
-	var err error = nil
-	//todo refactor mb use GetLinkByKey
-	if link == "" {
-		err = link2.ErrNotExist
-	}
-	if err!= nil {
+	r, err := m.getAliveLink(key)
+	if err != nil {
 		return "", err
 	}
-	m.StatsByKey[key] += 1
-	return link, err
+	r.useCounter += 1
+	return r.realLink, nil
 }
 
-func (m *Memory) DeleteLink(key string, userId string) (string, error) {
+func (m *Memory) IncreaseUseCounters(counts map[string]uint64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var link = m.linkByKey[key]
-	var err error = nil
-	if link == "" {
-		err = link2.ErrNotExist
+	for key, n := range counts {
+		if r, ok := m.linkByKey[key]; ok && !r.purged {
+			r.useCounter += n
+		}
+	}
+	return nil
+}
+
+// getAliveLink must be called with m.mu held.
+func (m *Memory) getAliveLink(key string) (*record, error) {
+	r, ok := m.linkByKey[key]
+	if !ok || !r.deletedAt.IsZero() {
+		return nil, link2.ErrNotExist
 	}
+	now := m.now()
+	if link2.Expired(r.expiresAt, now) {
+		return nil, link2.ErrExpired
+	}
+	if r.maxClicks != 0 && r.useCounter >= r.maxClicks {
+		return nil, link2.ErrExhausted
+	}
+	if r.paused {
+		return nil, link2.ErrPaused
+	}
+	if link2.NotActive(r.activeFrom, now) {
+		return nil, link2.ErrNotActive
+	}
+	return r, nil
+}
+
+func (m *Memory) GetLinkOwner(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.linkByKey[key]
+	if !ok || !r.deletedAt.IsZero() {
+		return "", link2.ErrNotExist
+	}
+	return r.creatorId, nil
+}
+
+// getOwnLink must be called with m.mu held.
+func (m *Memory) getOwnLink(key string, userId string) (*record, error) {
+	r, ok := m.linkByKey[key]
+	if !ok || !r.deletedAt.IsZero() {
+		return nil, link2.ErrNotExist
+	}
+	if r.creatorId == "" || r.creatorId != userId {
+		return nil, link2.ErrForbidden
+	}
+	return r, nil
+}
+
+func (m *Memory) DeleteLink(key string, userId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getOwnLink(key, userId)
 	if err != nil {
 		return "", err
 	}
-	delete(m.linkByKey, key)
-	delete(m.StatsByKey, key)
-	if userId != "" {
-		delete(m.userToLinksKeys[userId], link)
+	r.deletedAt = m.now()
+	return r.realLink, nil
+}
+
+func (m *Memory) GetTrash(userId string) ([]link2.LinkInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links := make([]link2.LinkInfo, 0)
+	for key := range m.userToLinksKeys[userId] {
+		if r := m.linkByKey[key]; !r.deletedAt.IsZero() {
+			links = append(links, r.info(key))
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if !links[i].DeletedAt.Equal(links[j].DeletedAt) {
+			return links[i].DeletedAt.After(links[j].DeletedAt)
+		}
+		return links[i].Key < links[j].Key
+	})
+	return links, nil
+}
+
+func (m *Memory) RestoreLink(key string, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.linkByKey[key]
+	if !ok || r.deletedAt.IsZero() || r.purged {
+		return link2.ErrNotExist
+	}
+	if r.creatorId != userId {
+		return link2.ErrForbidden
 	}
-	return link, err
+	r.deletedAt = time.Time{}
+	return nil
 }
 
-func (m *Memory) GetUserLinks(userId string) ([]string, error) {
+func (m *Memory) PurgeTrash(deletedBefore time.Time) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var links, ok = m.userToLinksKeys[userId]
-	if !ok {
-		return []string{}, link2.ErrNotExist
+	purged := 0
+	for key, r := range m.linkByKey {
+		if r.purged || r.deletedAt.IsZero() || !r.deletedAt.Before(deletedBefore) {
+			continue
+		}
+		if r.creatorId != "" {
+			delete(m.userToLinksKeys[r.creatorId], key)
+		}
+		delete(m.clicksByKey, key)
+		delete(m.sketchesByKey, key)
+		// the record stays as the tombstone of the key
+		m.linkByKey[key] = &record{createdAt: r.createdAt, deletedAt: r.deletedAt, purged: true}
+		purged++
 	}
-	keys := make([]string, 0, len(links))
-	for k, _ := range links {
-		keys = append(keys, k)
+	return purged, nil
+}
+
+func (m *Memory) UpdateLink(key string, userId string, realLink string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getOwnLink(key, userId)
+	if err != nil {
+		return err
 	}
-	return keys, nil
+	r.history = append(r.history, link2.DestinationChange{RealLink: r.realLink, ReplacedAt: m.now()})
+	r.realLink = realLink
+	return nil
 }
 
-func (m *Memory) GetLinkStat(link string) (uint64, error) {
+func (m *Memory) SetPasswordHash(key string, userId string, passwordHash string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var useCounter, ok = m.StatsByKey[link]
-	if !ok {
-		return 0, link2.ErrNotExist
+	r, err := m.getOwnLink(key, userId)
+	if err != nil {
+		return err
 	}
-	return useCounter, nil
+	r.passwordHash = passwordHash
+	return nil
+}
+
+func (m *Memory) SetPaused(key string, userId string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getOwnLink(key, userId)
+	if err != nil {
+		return err
+	}
+	r.paused = paused
+	return nil
+}
+
+func (m *Memory) GetLinkHistory(key string, userId string) ([]link2.DestinationChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getOwnLink(key, userId)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]link2.DestinationChange, len(r.history))
+	for i, change := range r.history {
+		history[len(history)-1-i] = change
+	}
+	return history, nil
+}
+
+func (m *Memory) GetUserLinks(userId string, query link2.ListQuery) (link2.LinkPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var cursor *link2.Cursor
+	if query.Cursor != "" {
+		c, err := link2.ParseCursor(query.Cursor)
+		if err != nil {
+			return link2.LinkPage{}, err
+		}
+		cursor = &c
+	}
+	contains := strings.ToLower(query.Contains)
+
+	links := make([]link2.LinkInfo, 0)
+	for key := range m.userToLinksKeys[userId] {
+		info := m.linkByKey[key].info(key)
+		if !info.DeletedAt.IsZero() {
+			continue
+		}
+		if contains != "" && !strings.Contains(strings.ToLower(info.RealLink), contains) {
+			continue
+		}
+		if cursor != nil && !cursor.After(info, query.SortBy, query.Ascending) {
+			continue
+		}
+		links = append(links, info)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return link2.CursorAt(links[i], query.SortBy).After(links[j], query.SortBy, query.Ascending)
+	})
+
+	page := link2.LinkPage{Links: links}
+	if query.Limit > 0 && len(links) > query.Limit {
+		page.Links = links[:query.Limit]
+		page.NextCursor = link2.CursorAt(page.Links[query.Limit-1], query.SortBy).String()
+	}
+	return page, nil
+}
+
+func (m *Memory) GetLinkStat(key string, userId string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getOwnLink(key, userId)
+	if err != nil {
+		return 0, err
+	}
+	return r.useCounter, nil
+}
+
+func (m *Memory) SetExpiration(key string, userId string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.getOwnLink(key, userId)
+	if err != nil {
+		return err
+	}
+	r.expiresAt = expiresAt
+	return nil
 }
 
 func (m *Memory) CreateUserLinksStorage(userId string) (string, error) {
@@ -126,4 +407,109 @@ func (m *Memory) CreateUserLinksStorage(userId string) (string, error) {
 	defer m.mu.Unlock()
 	m.userToLinksKeys[userId] = map[string]bool{}
 	return "", nil
-}
\ No newline at end of file
+}
+
+func (m *Memory) GetUserSettings(userId string) (link2.Settings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.settingsByUser[userId], nil
+}
+
+func (m *Memory) SaveUserSettings(userId string, settings link2.Settings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settingsByUser[userId] = settings
+	return nil
+}
+
+func (m *Memory) SaveClicks(clicks []link2.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range clicks {
+		m.clicksByKey[c.Key] = append(m.clicksByKey[c.Key], c)
+	}
+	return nil
+}
+
+func (m *Memory) CountClicks(query link2.ClickQuery) ([]link2.ClickBucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[int64]uint64)
+	for _, c := range m.clicksByKey[query.Key] {
+		if c.At.Before(query.From) || !c.At.Before(query.To) || !c.Matches(query.Bots) {
+			continue
+		}
+		counts[link2.TruncateTime(c.At, query.Interval, query.Location).Unix()]++
+	}
+	buckets := make([]link2.ClickBucket, 0, len(counts))
+	for start, n := range counts {
+		buckets = append(buckets, link2.ClickBucket{Start: time.Unix(start, 0).In(query.Location), Clicks: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets, nil
+}
+
+func (m *Memory) TopClickValues(query link2.BreakdownQuery) ([]link2.ValueCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]uint64)
+	for _, c := range m.clicksByKey[query.Key] {
+		if c.At.Before(query.From) || !c.At.Before(query.To) || !c.Matches(query.Bots) {
+			continue
+		}
+		counts[c.Value(query.Dimension)]++
+	}
+	values := make([]link2.ValueCount, 0, len(counts))
+	for v, n := range counts {
+		values = append(values, link2.ValueCount{Value: v, Clicks: n})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Clicks != values[j].Clicks {
+			return values[i].Clicks > values[j].Clicks
+		}
+		return values[i].Value < values[j].Value
+	})
+	if query.Limit > 0 && len(values) > query.Limit {
+		values = values[:query.Limit]
+	}
+	return values, nil
+}
+
+func (m *Memory) SaveVisitorSketches(sketches []link2.VisitorSketch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, vs := range sketches {
+		hours, ok := m.sketchesByKey[vs.Key]
+		if !ok {
+			hours = make(map[int64]*hll.Sketch)
+			m.sketchesByKey[vs.Key] = hours
+		}
+		stored, ok := hours[vs.Hour.Unix()]
+		if !ok {
+			stored = hll.New(vs.Sketch.Precision())
+			hours[vs.Hour.Unix()] = stored
+		}
+		if err := stored.Merge(vs.Sketch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetVisitorSketches(key string, from time.Time, to time.Time) ([]link2.VisitorSketch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sketches := make([]link2.VisitorSketch, 0)
+	for hour, stored := range m.sketchesByKey[key] {
+		start := time.Unix(hour, 0)
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+		sketch := hll.New(stored.Precision())
+		sketch.Merge(stored)
+		sketches = append(sketches, link2.VisitorSketch{Key: key, Hour: start, Sketch: sketch})
+	}
+	return sketches, nil
+}
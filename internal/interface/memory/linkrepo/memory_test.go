@@ -0,0 +1,263 @@
+package linkrepo
+
+import (
+	link2 "koro.che/internal/domain/link"
+	"testing"
+	"time"
+)
+
+func newTestMemory(t *testing.T, users ...string) *Memory {
+	m := NewMemory()
+	for _, u := range users {
+		if _, err := m.CreateUserLinksStorage(u); err != nil {
+			t.Fatalf("failed to create links storage: %v", err)
+		}
+	}
+	return m
+}
+
+func Test_foreignLinks(t *testing.T) {
+	m := newTestMemory(t, "alice", "bob")
+	if _, err := link2.CreateShortLink(m, "example.com", "alice", "alice1", time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	if _, err := link2.CreateShortLink(m, "example.org", "", "anon1", time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	t.Run("owner of link", func(t *testing.T) {
+		owner, err := m.GetLinkOwner("alice1")
+		if err != nil || owner != "alice" {
+			t.Errorf("Owner MUST be %q, but %q (%v) given", "alice", owner, err)
+		}
+	})
+	t.Run("another account can't read stats", func(t *testing.T) {
+		if _, err := m.GetLinkStat("alice1", "bob"); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+	})
+	t.Run("another account can't delete", func(t *testing.T) {
+		if _, err := m.DeleteLink("alice1", "bob"); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+		if _, err := m.GetLinkByKey("alice1"); err != nil {
+			t.Errorf("Link MUST survive foreign delete, but %v given", err)
+		}
+	})
+	t.Run("another account can't change expiration", func(t *testing.T) {
+		if err := m.SetExpiration("alice1", "bob", time.Now()); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+	})
+	t.Run("nobody owns anonymous links", func(t *testing.T) {
+		if _, err := m.DeleteLink("anon1", ""); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+	})
+	t.Run("foreign links are not listed", func(t *testing.T) {
+		page, err := m.GetUserLinks("bob", link2.ListQuery{})
+		if err != nil || len(page.Links) != 0 {
+			t.Errorf("Storage MUST return no links, but %v (%v) given", page.Links, err)
+		}
+	})
+	t.Run("owner reads stats and deletes", func(t *testing.T) {
+		if _, err := m.GetLinkStat("alice1", "alice"); err != nil {
+			t.Errorf("Owner MUST read stats, but %v given", err)
+		}
+		if _, err := m.DeleteLink("alice1", "alice"); err != nil {
+			t.Errorf("Owner MUST delete link, but %v given", err)
+		}
+		if _, err := m.GetLinkByKey("alice1"); err != link2.ErrNotExist {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrNotExist, err)
+		}
+	})
+}
+
+func Test_GetUserLinksPages(t *testing.T) {
+	m := newTestMemory(t, "alice")
+	clicks := map[string]int{"aaaa": 3, "bbbb": 1, "cccc": 2, "dddd": 2, "eeee": 0}
+	for key, n := range clicks {
+		if _, err := link2.CreateShortLink(m, "example.com/"+key, "alice", key, time.Time{}); err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			if _, err := m.MakeRedirect(key); err != nil {
+				t.Fatalf("failed to redirect: %v", err)
+			}
+		}
+	}
+
+	t.Run("most clicked first", func(t *testing.T) {
+		query := link2.ListQuery{SortBy: link2.SortByClicks, Limit: 2}
+		var keys []string
+		for {
+			page, err := m.GetUserLinks("alice", query)
+			if err != nil {
+				t.Fatalf("failed to get links: %v", err)
+			}
+			for _, info := range page.Links {
+				keys = append(keys, info.Key)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		expected := []string{"aaaa", "dddd", "cccc", "bbbb", "eeee"}
+		if len(keys) != len(expected) {
+			t.Fatalf("Storage MUST return %v, but %v given", expected, keys)
+		}
+		for i := range expected {
+			if keys[i] != expected[i] {
+				t.Fatalf("Storage MUST return %v, but %v given", expected, keys)
+			}
+		}
+	})
+	t.Run("filter by destination", func(t *testing.T) {
+		page, err := m.GetUserLinks("alice", link2.ListQuery{Contains: "COM/CC"})
+		if err != nil || len(page.Links) != 1 || page.Links[0].Key != "cccc" {
+			t.Errorf("Storage MUST return only cccc, but %v (%v) given", page.Links, err)
+		}
+	})
+	t.Run("invalid cursor", func(t *testing.T) {
+		if _, err := m.GetUserLinks("alice", link2.ListQuery{Cursor: "!"}); err != link2.ErrInvalidCursor {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrInvalidCursor, err)
+		}
+	})
+}
+
+func Test_UpdateLink(t *testing.T) {
+	m := newTestMemory(t, "alice", "bob")
+	link2.CreateShortLink(m, "example.com/v1", "alice", "sale", time.Time{})
+	for _, destination := range []string{"example.com/v2", "example.com/v3"} {
+		if err := m.UpdateLink("sale", "alice", destination); err != nil {
+			t.Fatalf("failed to update link: %v", err)
+		}
+	}
+	if realLink, _ := m.GetLinkByKey("sale"); realLink != "example.com/v3" {
+		t.Errorf("Link MUST lead to the new destination, but %q given", realLink)
+	}
+	history, err := m.GetLinkHistory("sale", "alice")
+	if err != nil || len(history) != 2 || history[0].RealLink != "example.com/v2" || history[1].RealLink != "example.com/v1" {
+		t.Errorf("History MUST have previous destinations from the latest, but %+v (%v) given", history, err)
+	}
+	if err := m.UpdateLink("sale", "bob", "evil.com"); err != link2.ErrForbidden {
+		t.Errorf("Foreign link MUST NOT be updated, but %v given", err)
+	}
+	if _, err := m.GetLinkHistory("sale", "bob"); err != link2.ErrForbidden {
+		t.Errorf("History of foreign link MUST NOT be read, but %v given", err)
+	}
+}
+
+func Test_ActiveWindow(t *testing.T) {
+	start := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	now := start.Add(-time.Minute)
+	m := newTestMemory(t, "alice").WithClock(func() time.Time { return now })
+	window := link2.NewLink{RealLink: "example.com/sale", Key: "sale", ActiveFrom: start, ExpiresAt: start.Add(time.Hour)}
+	if _, err := m.CreateShortLinks("alice", []link2.NewLink{window}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		at     time.Time
+		err    error
+		status string
+	}{
+		{"before start", start.Add(-time.Nanosecond), link2.ErrNotActive, link2.StatusScheduled},
+		{"at start", start, nil, link2.StatusActive},
+		{"before end", start.Add(time.Hour - time.Nanosecond), nil, link2.StatusActive},
+		{"at end", start.Add(time.Hour), link2.ErrExpired, link2.StatusExpired},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = c.at
+			if _, err := m.MakeRedirect("sale"); err != c.err {
+				t.Errorf("Redirect MUST give %v, but %v given", c.err, err)
+			}
+			page, _ := m.GetUserLinks("alice", link2.ListQuery{SortBy: link2.SortByCreated})
+			if status := page.Links[0].Status(now); status != c.status {
+				t.Errorf("Status MUST be %q, but %q given", c.status, status)
+			}
+		})
+	}
+
+	now = start.Add(-time.Minute)
+	if _, err := m.FindUserLink("alice", "example.com/sale"); err != link2.ErrNotExist {
+		t.Errorf("Scheduled link MUST NOT be reused before start, but %v given", err)
+	}
+}
+
+func Test_SetPaused(t *testing.T) {
+	m := newTestMemory(t, "alice", "bob")
+	link2.CreateShortLink(m, "example.com", "alice", "sale", time.Time{})
+	m.MakeRedirect("sale")
+
+	if err := m.SetPaused("sale", "bob", true); err != link2.ErrForbidden {
+		t.Errorf("Foreign link MUST NOT be paused, but %v given", err)
+	}
+	if err := m.SetPaused("sale", "alice", true); err != nil {
+		t.Fatalf("failed to pause link: %v", err)
+	}
+	if _, err := m.MakeRedirect("sale"); err != link2.ErrPaused {
+		t.Errorf("Paused link MUST NOT redirect, but %v given", err)
+	}
+	page, _ := m.GetUserLinks("alice", link2.ListQuery{SortBy: link2.SortByCreated})
+	if info := page.Links[0]; info.Status(time.Now()) != link2.StatusPaused || info.UseCounter != 1 {
+		t.Errorf("Paused link MUST be listed with its stats, but %+v given", info)
+	}
+
+	if err := m.SetPaused("sale", "alice", false); err != nil {
+		t.Fatalf("failed to resume link: %v", err)
+	}
+	if realLink, err := m.MakeRedirect("sale"); err != nil || realLink != "example.com" {
+		t.Errorf("Resumed link MUST redirect, but %q (%v) given", realLink, err)
+	}
+}
+
+func Test_Trash(t *testing.T) {
+	now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	m := newTestMemory(t, "alice", "bob").WithClock(func() time.Time { return now })
+	link2.CreateShortLink(m, "example.com", "alice", "sale", time.Time{})
+	m.MakeRedirect("sale")
+
+	if _, err := m.DeleteLink("sale", "alice"); err != nil {
+		t.Fatalf("failed to delete link: %v", err)
+	}
+	if _, err := m.MakeRedirect("sale"); err != link2.ErrNotExist {
+		t.Errorf("Deleted link MUST NOT redirect, but %v given", err)
+	}
+	if page, _ := m.GetUserLinks("alice", link2.ListQuery{SortBy: link2.SortByCreated}); len(page.Links) != 0 {
+		t.Errorf("Deleted link MUST NOT be listed, but %+v given", page.Links)
+	}
+	trash, _ := m.GetTrash("alice")
+	if len(trash) != 1 || trash[0].Key != "sale" || !trash[0].DeletedAt.Equal(now) {
+		t.Errorf("Deleted link MUST be in the trash, but %+v given", trash)
+	}
+	if err := m.RestoreLink("sale", "bob"); err != link2.ErrForbidden {
+		t.Errorf("Foreign link MUST NOT be restored, but %v given", err)
+	}
+	if err := m.RestoreLink("sale", "alice"); err != nil {
+		t.Fatalf("failed to restore link: %v", err)
+	}
+	if n, _ := m.GetLinkStat("sale", "alice"); n != 1 {
+		t.Errorf("Restored link MUST keep its stats, but %d uses given", n)
+	}
+
+	m.DeleteLink("sale", "alice")
+	if n, _ := m.PurgeTrash(now); n != 0 {
+		t.Errorf("Links deleted at the moment MUST NOT be purged, but %d given", n)
+	}
+	if n, _ := m.PurgeTrash(now.Add(time.Second)); n != 1 {
+		t.Errorf("Link MUST be purged, but %d given", n)
+	}
+	if trash, _ := m.GetTrash("alice"); len(trash) != 0 {
+		t.Errorf("Purged link MUST leave the trash, but %+v given", trash)
+	}
+	if err := m.RestoreLink("sale", "alice"); err != link2.ErrNotExist {
+		t.Errorf("Purged link MUST NOT be restored, but %v given", err)
+	}
+	if _, err := link2.CreateShortLink(m, "evil.com", "bob", "sale", time.Time{}); err != link2.ErrAliasTaken {
+		t.Errorf("Key of purged link MUST stay taken, but %v given", err)
+	}
+}
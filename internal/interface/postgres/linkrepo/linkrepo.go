@@ -2,27 +2,29 @@ package linkrepo
 
 import (
 	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
 	link2 "koro.che/internal/domain/link"
-	"math/rand"
+	"koro.che/internal/hll"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Postgres struct {
 	conn *sql.DB
+	now  func() time.Time
 }
 
 func New(conn *sql.DB) *Postgres {
-	return &Postgres{conn: conn}
+	return &Postgres{conn: conn, now: time.Now}
 }
 
-const allowedLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-const linkSize = 6
-
-func RandString() string {
-	b := make([]byte, linkSize)
-	for i := range b {
-		b[i] = allowedLetters[rand.Intn(len(allowedLetters))]
-	}
-	return string(b)
+// WithClock makes the storage tell the time by now, so tests can move it.
+// Links are checked against the time of the clock rather than of the database.
+func (p *Postgres) WithClock(now func() time.Time) *Postgres {
+	p.now = now
+	return p
 }
 
 type LinkInfo struct {
@@ -33,114 +35,828 @@ type LinkInfo struct {
 	UseCounter int
 }
 
-const queryCreateLink = `
-	insert into 
-	    links(creator_id, real_link, key) 
-	    values ($1, $2, $3)
+const queryCreateLinks = `
+	insert into links(creator_id, real_link, key, expires_at, normalized_link, use_counter, max_clicks, active_from, password_hash)
+	select $1::int, l.real_link, l.key, l.expires_at, l.normalized_link, l.use_counter, nullif(l.max_clicks, 0), l.active_from,
+		nullif(l.password_hash, '')
+	from unnest($2::text[], $3::text[], $4::timestamptz[], $5::text[], $6::int[], $7::bigint[], $8::timestamptz[], $9::text[])
+		as l(real_link, key, expires_at, normalized_link, use_counter, max_clicks, active_from, password_hash)
+	on conflict (key) do nothing
+	returning key
+`
+
+const queryFindUserLink = `
+	select key, real_link, created_at, active_from, expires_at, use_counter, coalesce(max_clicks, 0) from links
+	where creator_id is not distinct from $1 and normalized_link = $2
+		and (expires_at is null or expires_at > $3)
+		and password_hash is null and max_clicks is null and active_from is null
+		and not paused and deleted_at is null
+	order by created_at desc
+	limit 1
 `
 
-const queryGetRealLinkByKey = `
-	select real_link from links 
-	where key = $1
+const queryLinkInfo = `
+	select key, real_link, created_at, active_from, expires_at, use_counter,
+		coalesce(password_hash, ''), coalesce(max_clicks, 0), paused
+	from links
+	where key = $1 and deleted_at is null
 `
-const queryIncreaseLinkStat = `
+
+// the sequence is incremented by link2.KeyIdBlockSize
+const queryReserveKeyIds = `
+	select nextval('link_key_ids')
+`
+
+const queryAllKeys = `
+	select key from links
+	where deleted_at is null
+`
+
+// queryMakeRedirect counts the redirect only if the link is alive, active, not
+// paused and below its click limit, the row lock makes concurrent redirects
+// wait for each other.
+const queryMakeRedirect = `
 	update links
 		set use_counter = use_counter + 1
-	where key = $1
+	where key = $1 and (expires_at is null or expires_at > $2)
+		and (max_clicks is null or use_counter < max_clicks)
+		and (active_from is null or active_from <= $2)
+		and not paused and deleted_at is null
+	returning real_link
+`
+
+const queryIncreaseLinkStats = `
+	update links
+		set use_counter = use_counter + c.n
+	from unnest($1::text[], $2::bigint[]) as c(key, n)
+	where links.key = c.key and links.purged_at is null
 `
 
 const queryDeleteLink = `
-	delete from links
-	where key = $1
+	update links
+		set deleted_at = $3
+	where key = $1 and creator_id = $2 and deleted_at is null
+	returning real_link
+`
+
+const queryTrash = `
+	select key, real_link, created_at, active_from, expires_at, use_counter, coalesce(max_clicks, 0), paused, deleted_at
+	from links
+	where creator_id = $1 and deleted_at is not null and purged_at is null
+	order by deleted_at desc, key
 `
 
+const queryRestoreLink = `
+	update links
+		set deleted_at = null
+	where key = $1 and creator_id = $2 and deleted_at is not null and purged_at is null
+`
+
+const queryTrashOwner = `
+	select creator_id from links
+	where key = $1 and deleted_at is not null and purged_at is null
+`
+
+// queryPurgeTrash keeps rows of purged links as tombstones of their keys, so
+// the unique constraint keeps the keys from new links.
+const queryPurgeTrash = `
+	with purged as (
+		update links
+			set real_link = null, normalized_link = null, password_hash = null, purged_at = $2
+		where deleted_at < $1 and purged_at is null
+		returning id, key
+	), history as (
+		delete from link_history h using purged where h.link_id = purged.id
+	), clicks as (
+		delete from link_clicks c using purged where c.link_key = purged.key
+	), sketches as (
+		delete from link_visitor_sketches s using purged where s.link_key = purged.key
+	)
+	select count(*) from purged
+`
+
+// queryUpdateLink keeps the previous destination in the history in the same statement.
+const queryUpdateLink = `
+	with old as (
+		select id, real_link from links
+		where key = $1 and creator_id = $2 and deleted_at is null
+		for update
+	), updated as (
+		update links
+			set real_link = $3, normalized_link = $4
+		from old
+		where links.id = old.id
+		returning old.id, old.real_link
+	)
+	insert into link_history(link_id, real_link, replaced_at)
+	select id, real_link, $5::timestamptz from updated
+`
+
+const queryLinkHistory = `
+	select h.real_link, h.replaced_at from link_history h
+	join links l on l.id = h.link_id
+	where l.key = $1 and l.creator_id = $2 and l.deleted_at is null
+	order by h.replaced_at desc, h.id desc
+`
+
+const queryLinkOwner = `
+	select creator_id from links
+	where key = $1 and deleted_at is null
+`
+
+// queryUserLinks is formatted with the sort column, the cursor comparison
+// operator and the sort direction.
 const queryUserLinks = `
-	select key from links
-	where creator_id = $1
+	select key, real_link, created_at, active_from, expires_at, use_counter, coalesce(max_clicks, 0), paused,
+		coalesce(password_hash, '')
+	from links
+	where creator_id = $1 and deleted_at is null
+		and real_link ilike '%%' || $2 || '%%' escape '\'
+		and ($3 or (%[1]s, key) %[2]s ($4, $5))
+	order by %[1]s %[3]s, key %[3]s
+	limit $6
 `
 
+var userLinksSortColumns = map[string]string{
+	link2.SortByCreated: "created_at",
+	link2.SortByClicks:  "use_counter",
+}
+
 const queryLinkStats = `
 	select use_counter from links
-	where key = $1 
+	where key = $1 and creator_id = $2 and deleted_at is null
 `
 
-func (p *Postgres) CreateShortLink(link string, userId string) (string, error) {
-	var key string
-	for {
-		key = RandString()
-		row := p.conn.QueryRow(queryGetRealLinkByKey, key)
-		err := row.Scan()
-		if err == sql.ErrNoRows {
-			p.conn.QueryRow(queryCreateLink, userId, link, key)
-		    // todo need wrapping???
-			break
+const queryUpdateExpiration = `
+	update links
+		set expires_at = $3
+	where key = $1 and creator_id = $2 and deleted_at is null
+`
+
+const queryUpdatePasswordHash = `
+	update links
+		set password_hash = $3
+	where key = $1 and creator_id = $2 and deleted_at is null
+`
+
+const queryUpdatePaused = `
+	update links
+		set paused = $3
+	where key = $1 and creator_id = $2 and deleted_at is null
+`
+
+const queryUserSettings = `
+	select max_ttl, alphabet, key_length from link_settings
+	where account_id = $1
+`
+
+const querySaveUserSettings = `
+	insert into
+	    link_settings(account_id, max_ttl, alphabet, key_length)
+	    values ($1, $2, $3, $4)
+	on conflict (account_id) do update
+		set max_ttl = excluded.max_ttl,
+		    alphabet = excluded.alphabet,
+		    key_length = excluded.key_length
+`
+
+// botsFilter selects clicks by the parameter of link2.BotsExclude,
+// link2.BotsInclude or link2.BotsOnly value.
+const botsFilter = `(%[1]s = 'include' or is_bot = (%[1]s = 'only'))`
+
+var queryCountClicks = `
+	select date_trunc($2, clicked_at at time zone $3) at time zone $3 as bucket, count(*)
+	from link_clicks
+	where link_key = $1 and clicked_at >= $4 and clicked_at < $5
+		and ` + fmt.Sprintf(botsFilter, "$6") + `
+	group by bucket
+	order by bucket
+`
+
+// queryTopClickValues is formatted with the column of the breakdown dimension.
+var queryTopClickValues = `
+	select coalesce(%[1]s, ''), count(*) as clicks
+	from link_clicks
+	where link_key = $1 and clicked_at >= $2 and clicked_at < $3
+		and ` + fmt.Sprintf(botsFilter, "$5") + `
+	group by 1
+	order by clicks desc, 1
+	limit $4
+`
+
+var clickDimensionColumns = map[string]string{
+	link2.DimensionBrowser:  "browser",
+	link2.DimensionOS:       "os",
+	link2.DimensionDevice:   "device",
+	link2.DimensionReferrer: "referrer_domain",
+	link2.DimensionCountry:  "country",
+	link2.DimensionRegion:   "region",
+	link2.DimensionCity:     "city",
+}
+
+const queryInsertVisitorSketch = `
+	insert into
+	    link_visitor_sketches(link_key, hour, sketch)
+	    values ($1, $2, $3)
+	on conflict (link_key, hour) do nothing
+`
+
+const queryLockVisitorSketch = `
+	select sketch from link_visitor_sketches
+	where link_key = $1 and hour = $2
+	for update
+`
+
+const queryUpdateVisitorSketch = `
+	update link_visitor_sketches
+		set sketch = $3
+	where link_key = $1 and hour = $2
+`
+
+const queryVisitorSketches = `
+	select hour, sketch from link_visitor_sketches
+	where link_key = $1 and hour >= $2 and hour < $3
+`
+
+func (p *Postgres) GetLinkByKey(key string) (string, error) {
+	info, err := p.GetLinkInfo(key)
+	return info.RealLink, err
+}
+
+func (p *Postgres) GetLinkInfo(key string) (link2.LinkInfo, error) {
+	var info link2.LinkInfo
+	var activeFrom, expiresAt sql.NullTime
+	row := p.conn.QueryRow(queryLinkInfo, key)
+	err := row.Scan(&info.Key, &info.RealLink, &info.CreatedAt, &activeFrom, &expiresAt, &info.UseCounter, &info.PasswordHash, &info.MaxClicks, &info.Paused)
+	if err == sql.ErrNoRows {
+		return link2.LinkInfo{}, link2.ErrNotExist
+	}
+	if err != nil {
+		return link2.LinkInfo{}, err
+	}
+	info.ActiveFrom, info.ExpiresAt = activeFrom.Time, expiresAt.Time
+	now := p.now()
+	if link2.Expired(info.ExpiresAt, now) {
+		return link2.LinkInfo{}, link2.ErrExpired
+	}
+	if info.Exhausted() {
+		return link2.LinkInfo{}, link2.ErrExhausted
+	}
+	if info.Paused {
+		return link2.LinkInfo{}, link2.ErrPaused
+	}
+	if link2.NotActive(info.ActiveFrom, now) {
+		return link2.LinkInfo{}, link2.ErrNotActive
+	}
+	return info, nil
+}
+
+// CreateShortLinks inserts all the links in one statement, links with taken
+// keys are skipped rather than fail the others.
+func (p *Postgres) CreateShortLinks(userId string, links []link2.NewLink) ([]error, error) {
+	errs := make([]error, len(links))
+	var realLinks, keys, destinations, passwordHashes []string
+	var activeFrom, expiresAt []sql.NullTime
+	var useCounters, maxClicks []int64
+	requested := make(map[string]bool, len(links))
+	for i, l := range links {
+		if requested[l.Key] {
+			errs[i] = link2.ErrAliasTaken
+			continue
 		}
+		requested[l.Key] = true
+		realLinks = append(realLinks, l.RealLink)
+		keys = append(keys, l.Key)
+		expiresAt = append(expiresAt, nullTime(l.ExpiresAt))
+		activeFrom = append(activeFrom, nullTime(l.ActiveFrom))
+		destinations = append(destinations, link2.NormalizeDestination(l.RealLink))
+		useCounters = append(useCounters, int64(l.UseCounter))
+		maxClicks = append(maxClicks, int64(l.MaxClicks))
+		passwordHashes = append(passwordHashes, l.PasswordHash)
 	}
-	return key, nil
+	rows, err := p.conn.Query(queryCreateLinks, nullString(userId),
+		pq.Array(realLinks), pq.Array(keys), pq.Array(expiresAt), pq.Array(destinations), pq.Array(useCounters), pq.Array(maxClicks), pq.Array(activeFrom),
+		pq.Array(passwordHashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	created := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		created[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, l := range links {
+		if errs[i] == nil && !created[l.Key] {
+			errs[i] = link2.ErrAliasTaken
+		}
+	}
+	return errs, nil
 }
 
-func (p *Postgres) GetLinkByKey(key string) (string, error) {
-	var realLink string
-	row := p.conn.QueryRow(queryGetRealLinkByKey, key)
-	err := row.Scan(&realLink)
-	if err != nil && err == sql.ErrNoRows {
-		return "", link2.ErrNotExist
+func (p *Postgres) FindUserLink(userId string, realLink string) (link2.LinkInfo, error) {
+	var info link2.LinkInfo
+	var activeFrom, expiresAt sql.NullTime
+	row := p.conn.QueryRow(queryFindUserLink, nullString(userId), link2.NormalizeDestination(realLink), p.now())
+	err := row.Scan(&info.Key, &info.RealLink, &info.CreatedAt, &activeFrom, &expiresAt, &info.UseCounter, &info.MaxClicks)
+	if err == sql.ErrNoRows {
+		return link2.LinkInfo{}, link2.ErrNotExist
 	}
-	return realLink, err
+	if err != nil {
+		return link2.LinkInfo{}, err
+	}
+	info.ActiveFrom, info.ExpiresAt = activeFrom.Time, expiresAt.Time
+	return info, nil
+}
+
+func (p *Postgres) ReserveKeyIds() (uint64, error) {
+	var first uint64
+	err := p.conn.QueryRow(queryReserveKeyIds).Scan(&first)
+	return first, err
+}
+
+func (p *Postgres) ForEachKey(fn func(key string) error) error {
+	rows, err := p.conn.Query(queryAllKeys)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 func (p *Postgres) MakeRedirect(key string) (string, error) {
 	var realLink string
-	row := p.conn.QueryRow(queryGetRealLinkByKey, key)
-	err := row.Scan(&realLink)
+	err := p.conn.QueryRow(queryMakeRedirect, key, p.now()).Scan(&realLink)
+	if err == sql.ErrNoRows {
+		return "", p.deadLinkError(key)
+	}
+	return realLink, err
+}
 
-	if err != nil && err == sql.ErrNoRows {
-		return "", link2.ErrNotExist
+// deadLinkError explains why a redirect found no link to count.
+func (p *Postgres) deadLinkError(key string) error {
+	if _, err := p.GetLinkInfo(key); err != nil {
+		return err
 	}
+	// the link has changed since the update, the redirect is refused anyway
+	return link2.ErrExhausted
+}
 
-	row = p.conn.QueryRow(queryIncreaseLinkStat, key)
+func (p *Postgres) GetLinkOwner(key string) (string, error) {
+	var creatorId sql.NullString
+	row := p.conn.QueryRow(queryLinkOwner, key)
+	err := row.Scan(&creatorId)
+	if err == sql.ErrNoRows {
+		return "", link2.ErrNotExist
+	}
+	return creatorId.String, err
+}
 
-	return realLink, err
+// notOwnLinkError explains why a query filtered by the link owner found nothing.
+func (p *Postgres) notOwnLinkError(key string) error {
+	if _, err := p.GetLinkOwner(key); err != nil {
+		return err
+	}
+	return link2.ErrForbidden
 }
 
 func (p *Postgres) DeleteLink(key string, userId string) (string, error) {
 	var realLink string
-	row := p.conn.QueryRow(queryGetRealLinkByKey, key)
+	row := p.conn.QueryRow(queryDeleteLink, key, nullString(userId), p.now())
 	err := row.Scan(&realLink)
-	if err != nil && err == sql.ErrNoRows {
-		return "", link2.ErrNotExist
+	if err == sql.ErrNoRows {
+		return "", p.notOwnLinkError(key)
 	}
 	if err != nil {
 		return "", err // todo wrapping
 	}
-	row = p.conn.QueryRow(queryDeleteLink, key)
+	return realLink, nil
+}
 
-	return realLink, err
+func (p *Postgres) GetTrash(userId string) ([]link2.LinkInfo, error) {
+	rows, err := p.conn.Query(queryTrash, nullString(userId))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make([]link2.LinkInfo, 0)
+	for rows.Next() {
+		var info link2.LinkInfo
+		var activeFrom, expiresAt sql.NullTime
+		if err := rows.Scan(&info.Key, &info.RealLink, &info.CreatedAt, &activeFrom, &expiresAt, &info.UseCounter, &info.MaxClicks, &info.Paused, &info.DeletedAt); err != nil {
+			return nil, err
+		}
+		info.ActiveFrom, info.ExpiresAt = activeFrom.Time, expiresAt.Time
+		links = append(links, info)
+	}
+	return links, rows.Err()
+}
+
+func (p *Postgres) RestoreLink(key string, userId string) error {
+	res, err := p.conn.Exec(queryRestoreLink, key, nullString(userId))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return p.notOwnTrashError(key)
+	}
+	return nil
 }
 
-func (p *Postgres) GetUserLinks(userId string) ([]string, error) {
-	var userLinks = make([]string, 0)
-	rows, err := p.conn.Query(queryUserLinks, userId)
+// notOwnTrashError explains why a link wasn't found in the trash of the user.
+func (p *Postgres) notOwnTrashError(key string) error {
+	var creatorId sql.NullString
+	err := p.conn.QueryRow(queryTrashOwner, key).Scan(&creatorId)
+	if err == sql.ErrNoRows {
+		return link2.ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	return link2.ErrForbidden
+}
+
+func (p *Postgres) PurgeTrash(deletedBefore time.Time) (int, error) {
+	var purged int
+	err := p.conn.QueryRow(queryPurgeTrash, deletedBefore, p.now()).Scan(&purged)
+	return purged, err
+}
+
+func (p *Postgres) UpdateLink(key string, userId string, realLink string) error {
+	res, err := p.conn.Exec(queryUpdateLink, key, nullString(userId), realLink, link2.NormalizeDestination(realLink), p.now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return p.notOwnLinkError(key)
+	}
+	return nil
+}
+
+func (p *Postgres) SetPasswordHash(key string, userId string, passwordHash string) error {
+	res, err := p.conn.Exec(queryUpdatePasswordHash, key, nullString(userId), nullString(passwordHash))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return p.notOwnLinkError(key)
+	}
+	return nil
+}
+
+func (p *Postgres) SetPaused(key string, userId string, paused bool) error {
+	res, err := p.conn.Exec(queryUpdatePaused, key, nullString(userId), paused)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return p.notOwnLinkError(key)
+	}
+	return nil
+}
+
+func (p *Postgres) GetLinkHistory(key string, userId string) ([]link2.DestinationChange, error) {
+	rows, err := p.conn.Query(queryLinkHistory, key, nullString(userId))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	history := make([]link2.DestinationChange, 0)
 	for rows.Next() {
-		var link string
-		if err := rows.Scan(&link); err != nil {
+		var change link2.DestinationChange
+		if err := rows.Scan(&change.RealLink, &change.ReplacedAt); err != nil {
 			return nil, err
 		}
-		userLinks = append(userLinks, link)
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		// an empty history may be of a foreign link
+		owner, err := p.GetLinkOwner(key)
+		if err != nil {
+			return nil, err
+		}
+		if owner == "" || owner != userId {
+			return nil, link2.ErrForbidden
+		}
+	}
+	return history, nil
+}
+
+func (p *Postgres) GetUserLinks(userId string, query link2.ListQuery) (link2.LinkPage, error) {
+	column, ok := userLinksSortColumns[query.SortBy]
+	if !ok {
+		column = userLinksSortColumns[link2.SortByCreated]
+	}
+	op, direction := "<", "desc"
+	if query.Ascending {
+		op, direction = ">", "asc"
+	}
+	var cursor link2.Cursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = link2.ParseCursor(query.Cursor); err != nil {
+			return link2.LinkPage{}, err
+		}
+	}
+	var cursorValue interface{} = cursor.Value
+	if query.SortBy != link2.SortByClicks {
+		cursorValue = time.Unix(0, cursor.Value)
 	}
-	return userLinks, nil
+	// one extra link tells whether there is a next page
+	limit := sql.NullInt64{Int64: int64(query.Limit) + 1, Valid: query.Limit > 0}
+
+	var userLinks = make([]link2.LinkInfo, 0)
+	rows, err := p.conn.Query(fmt.Sprintf(queryUserLinks, column, op, direction),
+		userId, escapeLike(query.Contains), query.Cursor == "", cursorValue, cursor.Key, limit)
+	if err != nil {
+		return link2.LinkPage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var info link2.LinkInfo
+		var activeFrom, expiresAt sql.NullTime
+		if err := rows.Scan(&info.Key, &info.RealLink, &info.CreatedAt, &activeFrom, &expiresAt, &info.UseCounter, &info.MaxClicks, &info.Paused, &info.PasswordHash); err != nil {
+			return link2.LinkPage{}, err
+		}
+		info.ActiveFrom, info.ExpiresAt = activeFrom.Time, expiresAt.Time
+		userLinks = append(userLinks, info)
+	}
+	if err := rows.Err(); err != nil {
+		return link2.LinkPage{}, err
+	}
+
+	page := link2.LinkPage{Links: userLinks}
+	if query.Limit > 0 && len(userLinks) > query.Limit {
+		page.Links = userLinks[:query.Limit]
+		page.NextCursor = link2.CursorAt(page.Links[query.Limit-1], query.SortBy).String()
+	}
+	return page, nil
 }
 
-func (p *Postgres) GetLinkStat(key string) (uint64, error) {
+func (p *Postgres) GetLinkStat(key string, userId string) (uint64, error) {
 	var stat uint64
-	row := p.conn.QueryRow(queryLinkStats, key)
+	row := p.conn.QueryRow(queryLinkStats, key, nullString(userId))
 	err := row.Scan(&stat) //todo wrapping
+	if err == sql.ErrNoRows {
+		return 0, p.notOwnLinkError(key)
+	}
 	return stat, err
 }
 
+func (p *Postgres) SetExpiration(key string, userId string, expiresAt time.Time) error {
+	res, err := p.conn.Exec(queryUpdateExpiration, key, nullString(userId), nullTime(expiresAt))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return p.notOwnLinkError(key)
+	}
+	return nil
+}
+
 func (p *Postgres) CreateUserLinksStorage(userId string) (string, error) {
 	return "", nil
 }
+
+func (p *Postgres) GetUserSettings(userId string) (link2.Settings, error) {
+	var maxTTL, keyLength sql.NullInt64
+	var alphabet sql.NullString
+	row := p.conn.QueryRow(queryUserSettings, userId)
+	err := row.Scan(&maxTTL, &alphabet, &keyLength)
+	if err == sql.ErrNoRows {
+		return link2.Settings{}, nil
+	}
+	if err != nil {
+		return link2.Settings{}, err
+	}
+	return link2.Settings{
+		MaxTTL:    time.Duration(maxTTL.Int64) * time.Second,
+		Alphabet:  alphabet.String,
+		KeyLength: int(keyLength.Int64),
+	}, nil
+}
+
+func (p *Postgres) SaveUserSettings(userId string, settings link2.Settings) error {
+	maxTTL := sql.NullInt64{Int64: int64(settings.MaxTTL / time.Second), Valid: settings.MaxTTL > 0}
+	keyLength := sql.NullInt64{Int64: int64(settings.KeyLength), Valid: settings.KeyLength > 0}
+	_, err := p.conn.Exec(querySaveUserSettings, userId, maxTTL, nullString(settings.Alphabet), keyLength)
+	return err
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match literally in a like pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// nullString maps an empty id of an anonymous user to SQL NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime maps a zero time of a never expiring link to SQL NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// IncreaseUseCounters updates all the links in one statement, so each hot row
+// is locked once per batch rather than once per redirect.
+func (p *Postgres) IncreaseUseCounters(counts map[string]uint64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	// rows are locked in the same order by concurrent flushes
+	sort.Strings(keys)
+	ns := make([]int64, len(keys))
+	for i, key := range keys {
+		ns[i] = int64(counts[key])
+	}
+	_, err := p.conn.Exec(queryIncreaseLinkStats, pq.Array(keys), pq.Array(ns))
+	return err
+}
+
+func (p *Postgres) SaveClicks(clicks []link2.Click) error {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(pq.CopyIn("link_clicks", "link_key", "clicked_at", "referrer", "user_agent", "ip",
+		"browser", "os", "device", "referrer_domain", "country", "region", "city", "is_bot"))
+	if err != nil {
+		return err
+	}
+	for _, c := range clicks {
+		if _, err := stmt.Exec(c.Key, c.At, c.Referrer, c.UserAgent, c.IP,
+			c.Browser, c.OS, c.Device, c.ReferrerDomain, c.Country, c.Region, c.City, c.IsBot); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) CountClicks(query link2.ClickQuery) ([]link2.ClickBucket, error) {
+	rows, err := p.conn.Query(queryCountClicks,
+		query.Key, query.Interval, query.Location.String(), query.From, query.To, query.Bots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	buckets := make([]link2.ClickBucket, 0)
+	for rows.Next() {
+		var b link2.ClickBucket
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.In(query.Location)
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+func (p *Postgres) TopClickValues(query link2.BreakdownQuery) ([]link2.ValueCount, error) {
+	column, ok := clickDimensionColumns[query.Dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown click dimension %q", query.Dimension)
+	}
+	limit := sql.NullInt64{Int64: int64(query.Limit), Valid: query.Limit > 0}
+	rows, err := p.conn.Query(fmt.Sprintf(queryTopClickValues, column), query.Key, query.From, query.To, limit, query.Bots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]link2.ValueCount, 0)
+	for rows.Next() {
+		var v link2.ValueCount
+		if err := rows.Scan(&v.Value, &v.Clicks); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (p *Postgres) SaveVisitorSketches(sketches []link2.VisitorSketch) error {
+	tx, err := p.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// rows are locked in the same order by all writers, so they never deadlock
+	sorted := append([]link2.VisitorSketch(nil), sketches...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Key != sorted[j].Key {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return sorted[i].Hour.Before(sorted[j].Hour)
+	})
+	for _, vs := range sorted {
+		if err := saveVisitorSketch(tx, vs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func saveVisitorSketch(tx *sql.Tx, vs link2.VisitorSketch) error {
+	b, err := vs.Sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(queryInsertVisitorSketch, vs.Key, vs.Hour, b)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return err
+	}
+	// the hour already has a sketch, it is locked till the end of the transaction
+	var stored []byte
+	if err := tx.QueryRow(queryLockVisitorSketch, vs.Key, vs.Hour).Scan(&stored); err != nil {
+		return err
+	}
+	var merged hll.Sketch
+	if err := merged.UnmarshalBinary(stored); err != nil {
+		return err
+	}
+	if err := merged.Merge(vs.Sketch); err != nil {
+		return err
+	}
+	if b, err = merged.MarshalBinary(); err != nil {
+		return err
+	}
+	_, err = tx.Exec(queryUpdateVisitorSketch, vs.Key, vs.Hour, b)
+	return err
+}
+
+func (p *Postgres) GetVisitorSketches(key string, from time.Time, to time.Time) ([]link2.VisitorSketch, error) {
+	rows, err := p.conn.Query(queryVisitorSketches, key, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sketches := make([]link2.VisitorSketch, 0)
+	for rows.Next() {
+		vs := link2.VisitorSketch{Key: key, Sketch: &hll.Sketch{}}
+		var b []byte
+		if err := rows.Scan(&vs.Hour, &b); err != nil {
+			return nil, err
+		}
+		if err := vs.Sketch.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		sketches = append(sketches, vs)
+	}
+	return sketches, rows.Err()
+}
@@ -0,0 +1,334 @@
+package linkrepo
+
+import (
+	"database/sql"
+	"fmt"
+	"koro.che/internal/domain/account"
+	link2 "koro.che/internal/domain/link"
+	"koro.che/internal/interface/postgres/accountrepo"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// The tests run against a database initialized with initdb.sql,
+// its connection string is taken from KOROCHE_TEST_DB.
+func newTestPostgres(t *testing.T) (*Postgres, *sql.DB) {
+	connStr := os.Getenv("KOROCHE_TEST_DB")
+	if connStr == "" {
+		t.Skip("KOROCHE_TEST_DB is not set")
+	}
+	conn, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("failed to connect to DB: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return New(conn), conn
+}
+
+func createTestAccount(t *testing.T, conn *sql.DB, name string) string {
+	login := fmt.Sprintf("%s%d", name, time.Now().UnixNano())
+	acc, err := accountrepo.New(conn).CreateAccount(account.Credentials{Login: login, Password: "password"})
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	return acc.Id
+}
+
+func testKey(name string) string {
+	return fmt.Sprintf("%s%d", name, time.Now().UnixNano())
+}
+
+func Test_foreignLinks(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	bob := createTestAccount(t, conn, "bob")
+	aliceKey := testKey("alice")
+	anonKey := testKey("anon")
+	if _, err := link2.CreateShortLink(p, "example.com", alice, aliceKey, time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	if _, err := link2.CreateShortLink(p, "example.org", "", anonKey, time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	t.Run("owner of link", func(t *testing.T) {
+		owner, err := p.GetLinkOwner(aliceKey)
+		if err != nil || owner != alice {
+			t.Errorf("Owner MUST be %q, but %q (%v) given", alice, owner, err)
+		}
+	})
+	t.Run("another account can't read stats", func(t *testing.T) {
+		if _, err := p.GetLinkStat(aliceKey, bob); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+	})
+	t.Run("another account can't delete", func(t *testing.T) {
+		if _, err := p.DeleteLink(aliceKey, bob); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+		if _, err := p.GetLinkByKey(aliceKey); err != nil {
+			t.Errorf("Link MUST survive foreign delete, but %v given", err)
+		}
+	})
+	t.Run("another account can't change expiration", func(t *testing.T) {
+		if err := p.SetExpiration(aliceKey, bob, time.Now()); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+	})
+	t.Run("nobody owns anonymous links", func(t *testing.T) {
+		if _, err := p.DeleteLink(anonKey, bob); err != link2.ErrForbidden {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrForbidden, err)
+		}
+	})
+	t.Run("foreign links are not listed", func(t *testing.T) {
+		page, err := p.GetUserLinks(bob, link2.ListQuery{})
+		if err != nil || len(page.Links) != 0 {
+			t.Errorf("Storage MUST return no links, but %v (%v) given", page.Links, err)
+		}
+	})
+	t.Run("owner reads stats and deletes", func(t *testing.T) {
+		if _, err := p.GetLinkStat(aliceKey, alice); err != nil {
+			t.Errorf("Owner MUST read stats, but %v given", err)
+		}
+		if _, err := p.DeleteLink(aliceKey, alice); err != nil {
+			t.Errorf("Owner MUST delete link, but %v given", err)
+		}
+		if _, err := p.GetLinkByKey(aliceKey); err != link2.ErrNotExist {
+			t.Errorf("Storage MUST return %v, but %v given", link2.ErrNotExist, err)
+		}
+	})
+}
+
+func Test_IncreaseUseCounters(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	sale, news := testKey("sale"), testKey("news")
+	for _, key := range []string{sale, news} {
+		if _, err := link2.CreateShortLink(p, "example.com", alice, key, time.Time{}); err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+	}
+	if err := p.IncreaseUseCounters(map[string]uint64{sale: 3, news: 1, testKey("missing"): 2}); err != nil {
+		t.Fatalf("failed to increase counters: %v", err)
+	}
+	for key, expected := range map[string]uint64{sale: 3, news: 1} {
+		if n, err := p.GetLinkStat(key, alice); err != nil || n != expected {
+			t.Errorf("Link %s MUST have %d uses, but %d (%v) given", key, expected, n, err)
+		}
+	}
+}
+
+func Test_CreateShortLinks(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	taken, first, second := testKey("taken"), testKey("first"), testKey("second")
+	if _, err := link2.CreateShortLink(p, "example.com", alice, taken, time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	errs, err := p.CreateShortLinks(alice, []link2.NewLink{
+		{RealLink: "example.com/1", Key: first},
+		{RealLink: "example.com/2", Key: taken},
+		{RealLink: "example.com/3", Key: first},
+		{RealLink: "example.com/4", Key: second, ExpiresAt: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create links: %v", err)
+	}
+	expected := []error{nil, link2.ErrAliasTaken, link2.ErrAliasTaken, nil}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Errorf("Link %d MUST give %v, but %v given", i, expected[i], errs[i])
+		}
+	}
+	if realLink, err := p.GetLinkByKey(first); err != nil || realLink != "example.com/1" {
+		t.Errorf("Link MUST lead to example.com/1, but %q (%v) given", realLink, err)
+	}
+}
+
+func Test_UpdateLink(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	bob := createTestAccount(t, conn, "bob")
+	key := testKey("sale")
+	if _, err := link2.CreateShortLink(p, "example.com/v1", alice, key, time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	if history, err := p.GetLinkHistory(key, alice); err != nil || len(history) != 0 {
+		t.Errorf("New link MUST have empty history, but %+v (%v) given", history, err)
+	}
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	p.WithClock(func() time.Time { return now })
+	for _, destination := range []string{"example.com/v2", "example.com/v3"} {
+		now = now.Add(time.Hour)
+		if err := p.UpdateLink(key, alice, destination); err != nil {
+			t.Fatalf("failed to update link: %v", err)
+		}
+	}
+	if realLink, _ := p.GetLinkByKey(key); realLink != "example.com/v3" {
+		t.Errorf("Link MUST lead to the new destination, but %q given", realLink)
+	}
+	history, err := p.GetLinkHistory(key, alice)
+	if err != nil || len(history) != 2 || history[0].RealLink != "example.com/v2" || history[1].RealLink != "example.com/v1" {
+		t.Fatalf("History MUST have previous destinations from the latest, but %+v (%v) given", history, err)
+	}
+	if !history[0].ReplacedAt.Equal(now) || !history[1].ReplacedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("History MUST have times of the service clock, but %+v given", history)
+	}
+	if err := p.UpdateLink(key, bob, "evil.com"); err != link2.ErrForbidden {
+		t.Errorf("Foreign link MUST NOT be updated, but %v given", err)
+	}
+	if _, err := p.GetLinkHistory(key, bob); err != link2.ErrForbidden {
+		t.Errorf("History of foreign link MUST NOT be read, but %v given", err)
+	}
+}
+
+func Test_MaxClicks(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	key := testKey("invite")
+	const maxClicks = 3
+	if _, err := p.CreateShortLinks(alice, []link2.NewLink{{RealLink: "example.com", Key: key, MaxClicks: maxClicks}}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	results := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := p.MakeRedirect(key)
+			results <- err
+		}()
+	}
+	redirects := 0
+	for i := 0; i < 10; i++ {
+		switch err := <-results; err {
+		case nil:
+			redirects++
+		case link2.ErrExhausted:
+		default:
+			t.Errorf("Redirect MUST succeed or be exhausted, but %v given", err)
+		}
+	}
+	if redirects != maxClicks {
+		t.Errorf("Link MUST redirect %d times, but %d given", maxClicks, redirects)
+	}
+	if _, err := p.GetLinkInfo(key); err != link2.ErrExhausted {
+		t.Errorf("Exhausted link MUST NOT be found, but %v given", err)
+	}
+	page, err := p.GetUserLinks(alice, link2.ListQuery{SortBy: link2.SortByCreated, Limit: 10})
+	if err != nil || len(page.Links) != 1 || page.Links[0].Status(time.Now()) != link2.StatusExhausted {
+		t.Errorf("Owner MUST see the exhausted link, but %+v (%v) given", page.Links, err)
+	}
+}
+
+func Test_ActiveWindow(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	key := testKey("sale")
+	start := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	var now time.Time
+	p.WithClock(func() time.Time { return now })
+	window := link2.NewLink{RealLink: "example.com/sale", Key: key, ActiveFrom: start, ExpiresAt: start.Add(time.Hour)}
+	if _, err := p.CreateShortLinks(alice, []link2.NewLink{window}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		err  error
+	}{
+		{"before start", start.Add(-time.Microsecond), link2.ErrNotActive},
+		{"at start", start, nil},
+		{"before end", start.Add(time.Hour - time.Microsecond), nil},
+		{"at end", start.Add(time.Hour), link2.ErrExpired},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = c.at
+			if _, err := p.MakeRedirect(key); err != c.err {
+				t.Errorf("Redirect MUST give %v, but %v given", c.err, err)
+			}
+			if _, err := p.GetLinkInfo(key); err != c.err {
+				t.Errorf("Link info MUST give %v, but %v given", c.err, err)
+			}
+		})
+	}
+	if n, _ := p.GetLinkStat(key, alice); n != 2 {
+		t.Errorf("Only redirects within the window MUST be counted, but %d given", n)
+	}
+}
+
+func Test_SetPaused(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	bob := createTestAccount(t, conn, "bob")
+	key := testKey("sale")
+	if _, err := link2.CreateShortLink(p, "example.com", alice, key, time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	if err := p.SetPaused(key, bob, true); err != link2.ErrForbidden {
+		t.Errorf("Foreign link MUST NOT be paused, but %v given", err)
+	}
+	if err := p.SetPaused(key, alice, true); err != nil {
+		t.Fatalf("failed to pause link: %v", err)
+	}
+	if _, err := p.MakeRedirect(key); err != link2.ErrPaused {
+		t.Errorf("Paused link MUST NOT redirect, but %v given", err)
+	}
+	if _, err := p.GetLinkInfo(key); err != link2.ErrPaused {
+		t.Errorf("Paused link MUST NOT be found, but %v given", err)
+	}
+	if err := p.SetPaused(key, alice, false); err != nil {
+		t.Fatalf("failed to resume link: %v", err)
+	}
+	if _, err := p.MakeRedirect(key); err != nil {
+		t.Errorf("Resumed link MUST redirect, but %v given", err)
+	}
+}
+
+func Test_Trash(t *testing.T) {
+	p, conn := newTestPostgres(t)
+	alice := createTestAccount(t, conn, "alice")
+	bob := createTestAccount(t, conn, "bob")
+	key := testKey("sale")
+	deletedAt := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	p.WithClock(func() time.Time { return deletedAt })
+	if _, err := link2.CreateShortLink(p, "example.com", alice, key, time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	p.MakeRedirect(key)
+
+	if _, err := p.DeleteLink(key, alice); err != nil {
+		t.Fatalf("failed to delete link: %v", err)
+	}
+	if _, err := p.MakeRedirect(key); err != link2.ErrNotExist {
+		t.Errorf("Deleted link MUST NOT redirect, but %v given", err)
+	}
+	trash, err := p.GetTrash(alice)
+	if err != nil || len(trash) != 1 || trash[0].Key != key || !trash[0].DeletedAt.Equal(deletedAt) {
+		t.Errorf("Deleted link MUST be in the trash, but %+v (%v) given", trash, err)
+	}
+	if err := p.RestoreLink(key, bob); err != link2.ErrForbidden {
+		t.Errorf("Foreign link MUST NOT be restored, but %v given", err)
+	}
+	if err := p.RestoreLink(key, alice); err != nil {
+		t.Fatalf("failed to restore link: %v", err)
+	}
+	if n, _ := p.GetLinkStat(key, alice); n != 1 {
+		t.Errorf("Restored link MUST keep its stats, but %d uses given", n)
+	}
+
+	p.DeleteLink(key, alice)
+	if _, err := p.PurgeTrash(deletedAt.Add(time.Second)); err != nil {
+		t.Fatalf("failed to purge trash: %v", err)
+	}
+	if err := p.RestoreLink(key, alice); err != link2.ErrNotExist {
+		t.Errorf("Purged link MUST NOT be restored, but %v given", err)
+	}
+	if _, err := link2.CreateShortLink(p, "evil.com", bob, key, time.Time{}); err != link2.ErrAliasTaken {
+		t.Errorf("Key of purged link MUST stay taken, but %v given", err)
+	}
+}
@@ -0,0 +1,17 @@
+package prom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// WatchCounterBacklog exports the number of redirects which are counted in
+// memory but not saved to the storage yet.
+func WatchCounterBacklog(pending func() uint64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "link_counter_backlog",
+		Help: "Redirects waiting to be added to link use counters",
+	}, func() float64 {
+		return float64(pending())
+	})
+}
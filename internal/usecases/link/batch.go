@@ -0,0 +1,135 @@
+package link
+
+import (
+	"errors"
+	"koro.che/internal/domain/link"
+)
+
+var ErrBatchTooLarge = errors.New("too many links in batch")
+
+const (
+	MaxBatchSize = 500
+	// batchChunkSize limits the number of links stored by one query.
+	batchChunkSize = 100
+)
+
+// BatchItem is a link to shorten in a batch.
+type BatchItem struct {
+	RealLink string
+	ShortenOptions
+}
+
+// BatchResult is the result of shortening of a batch item, Err is set when
+// the item failed while the rest of the batch may succeed.
+type BatchResult struct {
+	ShortenResult
+	Err error
+}
+
+// pendingLink is a batch item waiting to be stored.
+type pendingLink struct {
+	index  int
+	link   link.NewLink
+	policy KeyPolicy
+}
+
+// ShortenLinks shortens every item like ShortenLink, but stores the links in
+// chunks rather than one by one.
+func (l *LinkUseCases) ShortenLinks(userId string, items []BatchItem) ([]BatchResult, error) {
+	if len(items) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	return l.createLinks(userId, items, nil)
+}
+
+// createLinks stores the batch, imported links give the initial use counters
+// and password hashes of the items when they are not nil.
+func (l *LinkUseCases) createLinks(userId string, items []BatchItem, imported []link.LinkInfo) ([]BatchResult, error) {
+	settings, err := l.shortenSettings(userId)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(items))
+	// links with aliases go first, so a generated key can't take an alias of the batch
+	var pending, generated []pendingLink
+	aliases := make(map[string]bool)
+	for i, item := range items {
+		newLink, policy, err := l.prepareLink(item.RealLink, userId, settings, item.ShortenOptions)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if imported != nil {
+			if imported[i].PasswordHash != "" && !validPasswordHash(imported[i].PasswordHash) {
+				results[i].Err = ErrInvalidPasswordHash
+				continue
+			}
+			newLink.UseCounter = imported[i].UseCounter
+			newLink.PasswordHash = imported[i].PasswordHash
+		}
+		result, ok, err := l.reuseLink(item.RealLink, userId, item.ShortenOptions)
+		if ok || err != nil {
+			results[i] = BatchResult{ShortenResult: result, Err: err}
+			continue
+		}
+		if item.Alias != "" {
+			if aliases[item.Alias] {
+				results[i].Err = link.ErrAliasTaken
+				continue
+			}
+			aliases[item.Alias] = true
+			pending = append(pending, pendingLink{index: i, link: newLink})
+		} else {
+			generated = append(generated, pendingLink{index: i, link: newLink, policy: policy})
+		}
+	}
+	pending = append(pending, generated...)
+
+	for attempt := 0; len(pending) > 0 && attempt < maxKeyAttempts; attempt++ {
+		for i := range pending {
+			if pending[i].policy == (KeyPolicy{}) {
+				continue
+			}
+			if pending[i].link.Key, err = l.keyGenerator().GenerateKey(pending[i].policy); err != nil {
+				return nil, err
+			}
+		}
+		var retry []pendingLink
+		for start := 0; start < len(pending); start += batchChunkSize {
+			end := start + batchChunkSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			retry = append(retry, l.storeChunk(userId, pending[start:end], results)...)
+		}
+		pending = retry
+	}
+	for _, p := range pending {
+		results[p.index].Err = ErrKeySpaceExhausted
+	}
+	return results, nil
+}
+
+// storeChunk saves the links into results and returns links with generated
+// keys which are taken, they get other keys.
+func (l *LinkUseCases) storeChunk(userId string, chunk []pendingLink, results []BatchResult) []pendingLink {
+	links := make([]link.NewLink, len(chunk))
+	for i, p := range chunk {
+		links[i] = p.link
+	}
+	errs, err := l.LinkStorage.CreateShortLinks(userId, links)
+	var retry []pendingLink
+	for i, p := range chunk {
+		switch {
+		case err != nil:
+			results[p.index].Err = err
+		case errs[i] == nil:
+			results[p.index].ShortLink = prefix + p.link.Key
+		case errs[i] == link.ErrAliasTaken && p.policy != (KeyPolicy{}):
+			retry = append(retry, p)
+		default:
+			results[p.index].Err = errs[i]
+		}
+	}
+	return retry
+}
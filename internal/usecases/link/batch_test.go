@@ -0,0 +1,66 @@
+package link
+
+import (
+	"fmt"
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+// collidingKeyGenerator returns the queued keys first, then unique ones.
+type collidingKeyGenerator struct {
+	keys []string
+	n    int
+}
+
+func (g *collidingKeyGenerator) GenerateKey(policy KeyPolicy) (string, error) {
+	if len(g.keys) > 0 {
+		key := g.keys[0]
+		g.keys = g.keys[1:]
+		return key, nil
+	}
+	g.n++
+	return fmt.Sprintf("gen%d", g.n), nil
+}
+
+func Test_ShortenLinks(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	link.CreateShortLink(storage, "example.net", "alice", "taken", time.Time{})
+	l := LinkUseCases{
+		LinkStorage:  storage,
+		KeyGenerator: &collidingKeyGenerator{keys: []string{"taken", "sale"}},
+	}
+	results, err := l.ShortenLinks("alice", []BatchItem{
+		{RealLink: "example.com/1"},
+		{RealLink: "example.com/2", ShortenOptions: ShortenOptions{Alias: "sale"}},
+		{RealLink: "example.com/3", ShortenOptions: ShortenOptions{Alias: "sale"}},
+		{RealLink: "example.com/4", ShortenOptions: ShortenOptions{Alias: "taken"}},
+		{RealLink: "example.com/5", ShortenOptions: ShortenOptions{Alias: "no"}},
+		{RealLink: "example.net", ShortenOptions: ShortenOptions{ReuseExisting: true}},
+	})
+	if err != nil {
+		t.Fatalf("failed to shorten batch: %v", err)
+	}
+	expected := []BatchResult{
+		{ShortenResult: ShortenResult{ShortLink: prefix + "gen1"}},
+		{ShortenResult: ShortenResult{ShortLink: prefix + "sale"}},
+		{Err: link.ErrAliasTaken},
+		{Err: link.ErrAliasTaken},
+		{Err: ErrTooShortAlias},
+		{ShortenResult: ShortenResult{ShortLink: prefix + "taken", Reused: true}},
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("Item %d MUST give %+v, but %+v given", i, expected[i], results[i])
+		}
+	}
+	if realLink, _ := storage.GetLinkByKey("sale"); realLink != "example.com/2" {
+		t.Errorf("Alias MUST be kept by its item, but it leads to %q", realLink)
+	}
+
+	if _, err := l.ShortenLinks("alice", make([]BatchItem, MaxBatchSize+1)); err != ErrBatchTooLarge {
+		t.Errorf("Use case MUST return %v, but %v given", ErrBatchTooLarge, err)
+	}
+}
@@ -0,0 +1,193 @@
+package link
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"koro.che/internal/domain/link"
+	"koro.che/internal/hll"
+	"koro.che/internal/useragent"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Visit describes the client following a short link.
+type Visit struct {
+	Referrer  string
+	UserAgent string
+	IP        string
+	// Prefetch is set for HEAD requests and browser prefetching or previews,
+	// they are not followed by a person.
+	Prefetch bool
+	// Password unlocks protected links.
+	Password string
+}
+
+// IsBot reports whether the visit is made by a program rather than a person.
+func (v Visit) IsBot() bool {
+	return v.Prefetch || useragent.IsBot(v.UserAgent)
+}
+
+// Locator finds where a client is by its address.
+type Locator interface {
+	Locate(ip string) link.GeoLocation
+}
+
+// ClickRecorder saves clicks in background batches, so redirects don't wait
+// for the storage.
+type ClickRecorder struct {
+	storage       link.Interface
+	clicks        chan link.Click
+	batchSize     int
+	flushInterval time.Duration
+	logger        zerolog.Logger
+	wg            sync.WaitGroup
+}
+
+func NewClickRecorder(storage link.Interface, bufferSize int, batchSize int, flushInterval time.Duration) *ClickRecorder {
+	r := &ClickRecorder{
+		storage:       storage,
+		clicks:        make(chan link.Click, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		logger:        log.With().Str("module", "click-recorder").Logger(),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// Record queues the click. It never blocks: when the buffer is full the click is dropped.
+func (r *ClickRecorder) Record(c link.Click) {
+	select {
+	case r.clicks <- c:
+	default:
+		r.logger.Warn().Str("key", c.Key).Msg("click buffer is full, click dropped")
+	}
+}
+
+// Close saves queued clicks and stops the recorder. Record must not be called after Close.
+func (r *ClickRecorder) Close() {
+	close(r.clicks)
+	r.wg.Wait()
+}
+
+func (r *ClickRecorder) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+	batch := make([]link.Click, 0, r.batchSize)
+	for {
+		select {
+		case c, ok := <-r.clicks:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, c)
+			if len(batch) >= r.batchSize {
+				batch = r.flush(batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(batch)
+		}
+	}
+}
+
+func (r *ClickRecorder) flush(batch []link.Click) []link.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := r.storage.SaveClicks(batch); err != nil {
+		r.logger.Error().Err(err).Int("clicks", len(batch)).Msg("failed to save clicks")
+	}
+	if err := r.storage.SaveVisitorSketches(visitorSketches(batch)); err != nil {
+		r.logger.Error().Err(err).Int("clicks", len(batch)).Msg("failed to save visitor sketches")
+	}
+	return batch[:0]
+}
+
+// visitorSketches counts unique human visitors of the clicks per link and hour.
+func visitorSketches(clicks []link.Click) []link.VisitorSketch {
+	type linkHour struct {
+		key  string
+		hour int64
+	}
+	sketches := make(map[linkHour]*hll.Sketch)
+	for _, c := range clicks {
+		if c.IsBot {
+			continue
+		}
+		lh := linkHour{c.Key, c.At.Truncate(time.Hour).Unix()}
+		s, ok := sketches[lh]
+		if !ok {
+			s = hll.New(hll.DefaultPrecision)
+			sketches[lh] = s
+		}
+		s.Add(c.VisitorHash)
+	}
+	result := make([]link.VisitorSketch, 0, len(sketches))
+	for lh, s := range sketches {
+		result = append(result, link.VisitorSketch{Key: lh.key, Hour: time.Unix(lh.hour, 0).UTC(), Sketch: s})
+	}
+	return result
+}
+
+// newClick describes the visit, personal data of the client is not kept as is.
+func (l *LinkUseCases) newClick(key string, visit Visit, at time.Time) link.Click {
+	ua := useragent.Parse(visit.UserAgent)
+	var geo link.GeoLocation
+	if l.Locator != nil {
+		geo = l.Locator.Locate(visit.IP)
+	}
+	return link.Click{
+		Key:            key,
+		At:             at,
+		Referrer:       visit.Referrer,
+		UserAgent:      visit.UserAgent,
+		IP:             anonymizeIP(visit.IP),
+		Browser:        ua.Browser,
+		OS:             ua.OS,
+		Device:         ua.Device,
+		ReferrerDomain: referrerDomain(visit.Referrer),
+		GeoLocation:    geo,
+		VisitorHash:    visitorHash(l.VisitorSalt, visit),
+		IsBot:          visit.IsBot(),
+	}
+}
+
+// visitorHash tells visitors apart without keeping their addresses: the salt
+// makes the hash useless for finding the address by brute force.
+func visitorHash(salt []byte, visit Visit) uint64 {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(visit.IP))
+	h.Write([]byte{0})
+	h.Write([]byte(visit.UserAgent))
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+func referrerDomain(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// anonymizeIP drops the host part of the address: the last octet of IPv4
+// and the last 80 bits of IPv6.
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,145 @@
+package link
+
+import (
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"sync"
+	"testing"
+	"time"
+)
+
+type clickStorageFake struct {
+	link.Interface
+	mu      sync.Mutex
+	batches [][]link.Click
+}
+
+func (s *clickStorageFake) SaveClicks(clicks []link.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]link.Click(nil), clicks...))
+	return nil
+}
+
+func (s *clickStorageFake) SaveVisitorSketches(sketches []link.VisitorSketch) error {
+	return nil
+}
+
+type locatorFake map[string]link.GeoLocation
+
+func (f locatorFake) Locate(ip string) link.GeoLocation {
+	return f[ip]
+}
+
+func Test_ClickRecorder(t *testing.T) {
+	storage := &clickStorageFake{}
+	r := NewClickRecorder(storage, 100, 2, time.Hour)
+	for _, key := range []string{"a", "b", "c"} {
+		r.Record(link.Click{Key: key})
+	}
+	r.Close()
+
+	if len(storage.batches) != 2 || len(storage.batches[0]) != 2 || len(storage.batches[1]) != 1 {
+		t.Errorf("Recorder MUST save batches of 2 and 1 clicks, but %v given", storage.batches)
+	}
+}
+
+func Test_newClickLocation(t *testing.T) {
+	london := link.GeoLocation{Country: "GB", Region: "England", City: "London"}
+	l := LinkUseCases{Locator: locatorFake{"81.2.69.142": london}}
+
+	click := l.newClick("sale", Visit{IP: "81.2.69.142", UserAgent: "Firefox"}, time.Now())
+	if click.GeoLocation != london {
+		t.Errorf("Click MUST be located at %+v, but %+v given", london, click.GeoLocation)
+	}
+	if click.IP != "81.2.69.0" {
+		t.Errorf("Click MUST keep the anonymized address, but %q given", click.IP)
+	}
+	if click := l.newClick("sale", Visit{IP: "10.0.0.1"}, time.Now()); click.GeoLocation != (link.GeoLocation{}) {
+		t.Errorf("Unknown address MUST have no location, but %+v given", click.GeoLocation)
+	}
+	without := LinkUseCases{}
+	if click := without.newClick("sale", Visit{IP: "81.2.69.142"}, time.Now()); click.GeoLocation != (link.GeoLocation{}) {
+		t.Errorf("Click MUST have no location without a locator, but %+v given", click.GeoLocation)
+	}
+}
+
+func Test_anonymizeIP(t *testing.T) {
+	cases := map[string]string{
+		"192.168.10.42":            "192.168.10.0",
+		"2001:db8:85a3:8d3:1319::": "2001:db8:85a3::",
+		"not an ip":                "",
+	}
+	for ip, expected := range cases {
+		if actual := anonymizeIP(ip); actual != expected {
+			t.Errorf("Address %q MUST be anonymized to %q, but %q given", ip, expected, actual)
+		}
+	}
+}
+
+func Test_referrerDomain(t *testing.T) {
+	cases := map[string]string{
+		"https://www.Google.com/search?q=koro.che": "google.com",
+		"http://t.me:8080/channel":                 "t.me",
+		"":                                         "",
+		"::not a url":                              "",
+	}
+	for referrer, expected := range cases {
+		if actual := referrerDomain(referrer); actual != expected {
+			t.Errorf("Referrer %q MUST have domain %q, but %q given", referrer, expected, actual)
+		}
+	}
+}
+
+func Test_visitorSketches(t *testing.T) {
+	l := LinkUseCases{VisitorSalt: []byte("salt")}
+	hour := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	alice := Visit{IP: "192.0.2.1", UserAgent: "Firefox"}
+	bob := Visit{IP: "192.0.2.2", UserAgent: "Firefox"}
+	clicks := []link.Click{
+		l.newClick("sale", alice, hour),
+		l.newClick("sale", alice, hour.Add(time.Minute)),
+		l.newClick("sale", bob, hour.Add(time.Minute)),
+		l.newClick("sale", bob, hour.Add(time.Hour)),
+	}
+	sketches := visitorSketches(clicks)
+	if len(sketches) != 2 {
+		t.Fatalf("Clicks MUST give sketches of 2 hours, but %d given", len(sketches))
+	}
+	for _, s := range sketches {
+		expected := uint64(1)
+		if s.Hour.Equal(hour) {
+			expected = 2
+		}
+		if actual := s.Sketch.Estimate(); actual != expected {
+			t.Errorf("Hour %v MUST have %d visitors, but %d given", s.Hour, expected, actual)
+		}
+	}
+}
+
+func Test_MakeRedirectOfBots(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	if _, err := link.CreateShortLink(storage, "example.com", "alice", "sale", time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	l := LinkUseCases{LinkStorage: storage}
+	visits := []Visit{
+		{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0 Safari/537.36"},
+		{UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"},
+		{UserAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"},
+		{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/90.0", Prefetch: true},
+	}
+	for _, v := range visits {
+		if _, err := l.MakeRedirect("sale", v); err != nil {
+			t.Fatalf("failed to redirect: %v", err)
+		}
+	}
+	stat, err := l.GetLinkStats("sale", "alice")
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stat.UseCounter != 1 {
+		t.Errorf("Only people MUST be counted, but %d uses given", stat.UseCounter)
+	}
+}
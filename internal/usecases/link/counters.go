@@ -0,0 +1,120 @@
+package link
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"koro.che/internal/domain/link"
+	"sync"
+	"time"
+)
+
+// CounterBuffer collects redirects per link in memory and adds them to use
+// counters in batches, so redirects of popular links don't wait for each other
+// on the same row.
+type CounterBuffer struct {
+	storage       link.Interface
+	batchSize     int
+	flushInterval time.Duration
+	logger        zerolog.Logger
+
+	mu      sync.Mutex
+	counts  map[string]uint64
+	pending uint64
+
+	full chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewCounterBuffer starts flushing counters every flushInterval or as soon as
+// batchSize links have pending redirects.
+func NewCounterBuffer(storage link.Interface, batchSize int, flushInterval time.Duration) *CounterBuffer {
+	b := &CounterBuffer{
+		storage:       storage,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		logger:        log.With().Str("module", "counter-buffer").Logger(),
+		counts:        make(map[string]uint64),
+		full:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// Add counts a redirect of the link.
+func (b *CounterBuffer) Add(key string) {
+	b.mu.Lock()
+	b.counts[key]++
+	b.pending++
+	full := len(b.counts) >= b.batchSize
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Pending returns the number of redirects which are not saved yet, including
+// the ones being saved right now.
+func (b *CounterBuffer) Pending() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending
+}
+
+// Close saves pending counters and stops the buffer. Add must not be called after Close.
+func (b *CounterBuffer) Close() {
+	close(b.done)
+	b.wg.Wait()
+}
+
+func (b *CounterBuffer) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			b.flush()
+			if n := b.Pending(); n > 0 {
+				b.logger.Error().Uint64("redirects", n).Msg("use counters are lost on shutdown")
+			}
+			return
+		case <-b.full:
+			b.flush()
+		case <-ticker.C:
+			b.flush()
+		}
+	}
+}
+
+func (b *CounterBuffer) flush() {
+	b.mu.Lock()
+	counts := b.counts
+	b.counts = make(map[string]uint64)
+	b.mu.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	if err := b.storage.IncreaseUseCounters(counts); err != nil {
+		b.logger.Error().Err(err).Int("links", len(counts)).Msg("failed to save use counters, retrying later")
+		// keep the counts for the next flush rather than lose them
+		b.mu.Lock()
+		for key, n := range counts {
+			b.counts[key] += n
+		}
+		b.mu.Unlock()
+		return
+	}
+	var saved uint64
+	for _, n := range counts {
+		saved += n
+	}
+	b.mu.Lock()
+	b.pending -= saved
+	b.mu.Unlock()
+}
@@ -0,0 +1,70 @@
+package link
+
+import (
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+func Test_CounterBuffer(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	for _, key := range []string{"sale", "news"} {
+		if _, err := link.CreateShortLink(storage, "example.com", "alice", key, time.Time{}); err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+	}
+	counters := NewCounterBuffer(storage, 1000, time.Hour)
+	l := LinkUseCases{LinkStorage: storage, Counters: counters}
+	for i := 0; i < 3; i++ {
+		if _, err := l.MakeRedirect("sale", Visit{UserAgent: "Firefox"}); err != nil {
+			t.Fatalf("failed to redirect: %v", err)
+		}
+	}
+	l.MakeRedirect("news", Visit{UserAgent: "Firefox"})
+	if _, err := l.MakeRedirect("missing", Visit{UserAgent: "Firefox"}); err == nil {
+		t.Errorf("Redirect of missing link MUST fail")
+	}
+	if pending := counters.Pending(); pending != 4 {
+		t.Errorf("Buffer MUST have 4 pending redirects, but %d given", pending)
+	}
+	if stat, _ := l.GetLinkStats("sale", "alice"); stat.UseCounter != 0 {
+		t.Errorf("Counter MUST NOT be saved before flush, but %d given", stat.UseCounter)
+	}
+
+	counters.Close()
+	expected := map[string]uint64{"sale": 3, "news": 1}
+	for key, n := range expected {
+		if stat, _ := l.GetLinkStats(key, "alice"); stat.UseCounter != n {
+			t.Errorf("Link %s MUST have %d uses after close, but %d given", key, n, stat.UseCounter)
+		}
+	}
+	if pending := counters.Pending(); pending != 0 {
+		t.Errorf("Buffer MUST be empty after close, but %d pending", pending)
+	}
+}
+
+func Test_CounterBufferFlushesFullBatch(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	keys := []string{"one", "two", "three"}
+	for _, key := range keys {
+		link.CreateShortLink(storage, "example.com", "alice", key, time.Time{})
+	}
+	counters := NewCounterBuffer(storage, len(keys), time.Hour)
+	defer counters.Close()
+	for _, key := range keys {
+		counters.Add(key)
+	}
+	deadline := time.Now().Add(time.Second)
+	for counters.Pending() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Full batch MUST be flushed before the interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n, _ := storage.GetLinkStat("three", "alice"); n != 1 {
+		t.Errorf("Link MUST have 1 use, but %d given", n)
+	}
+}
@@ -0,0 +1,49 @@
+package link
+
+import "koro.che/internal/domain/link"
+
+// MaxImportSize limits the number of links imported at once.
+const MaxImportSize = 10000
+
+// ExportLinks calls fn with every link of the account from the oldest to the
+// newest, links are read page by page.
+func (l *LinkUseCases) ExportLinks(userId string, fn func(info link.LinkInfo) error) error {
+	query := link.ListQuery{SortBy: link.SortByCreated, Ascending: true, Limit: maxPageSize}
+	for {
+		page, err := l.LinkStorage.GetUserLinks(userId, query)
+		if err != nil {
+			return err
+		}
+		for _, info := range page.Links {
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// ImportLinks recreates exported links of the account under the same keys,
+// with the same use counters and passwords. Links whose keys are taken fail
+// with link.ErrAliasTaken, expired ones with ErrExpirationInPast.
+func (l *LinkUseCases) ImportLinks(userId string, links []link.LinkInfo) ([]BatchResult, error) {
+	if len(links) > MaxImportSize {
+		return nil, ErrBatchTooLarge
+	}
+	items := make([]BatchItem, len(links))
+	for i, info := range links {
+		items[i] = BatchItem{
+			RealLink: info.RealLink,
+			ShortenOptions: ShortenOptions{
+				Alias:      info.Key,
+				Lifetime:   Lifetime{ExpiresAt: info.ExpiresAt},
+				MaxClicks:  info.MaxClicks,
+				ActiveFrom: info.ActiveFrom,
+			},
+		}
+	}
+	return l.createLinks(userId, items, links)
+}
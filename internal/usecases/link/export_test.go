@@ -0,0 +1,93 @@
+package link
+
+import (
+	"fmt"
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ExportImportLinks(t *testing.T) {
+	source := linkrepo.NewMemory()
+	source.CreateUserLinksStorage("alice")
+	// more links than fit into a page
+	for i := 0; i <= maxPageSize; i++ {
+		link.CreateShortLink(source, fmt.Sprintf("example.com/%d", i), "alice", fmt.Sprintf("key%d", i), time.Time{})
+	}
+	source.MakeRedirect("key0")
+	from := LinkUseCases{LinkStorage: source}
+	var exported []link.LinkInfo
+	err := from.ExportLinks("alice", func(info link.LinkInfo) error {
+		exported = append(exported, info)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to export links: %v", err)
+	}
+	if len(exported) != maxPageSize+1 {
+		t.Fatalf("Export MUST have %d links, but %d given", maxPageSize+1, len(exported))
+	}
+
+	target := linkrepo.NewMemory()
+	target.CreateUserLinksStorage("bob")
+	link.CreateShortLink(target, "example.org", "bob", "key1", time.Time{})
+	to := LinkUseCases{LinkStorage: target}
+	results, err := to.ImportLinks("bob", exported)
+	if err != nil {
+		t.Fatalf("failed to import links: %v", err)
+	}
+	for i, r := range results {
+		switch exported[i].Key {
+		case "key1":
+			if r.Err != link.ErrAliasTaken {
+				t.Errorf("Taken key MUST be reported, but %+v given", r)
+			}
+		default:
+			if r.Err != nil || r.ShortLink != prefix+exported[i].Key {
+				t.Errorf("Link MUST keep key %s, but %+v given", exported[i].Key, r)
+			}
+		}
+	}
+	if n, _ := target.GetLinkStat("key0", "bob"); n != 1 {
+		t.Errorf("Imported link MUST keep its use counter, but %d given", n)
+	}
+}
+
+func Test_ImportProtectedLinks(t *testing.T) {
+	source := linkrepo.NewMemory()
+	source.CreateUserLinksStorage("alice")
+	from := LinkUseCases{LinkStorage: source}
+	link.CreateShortLink(source, "example.com/secret", "alice", "secret", time.Time{})
+	if err := from.SetLinkPassword("secret", "alice", "open sesame"); err != nil {
+		t.Fatalf("failed to set password: %v", err)
+	}
+	var exported []link.LinkInfo
+	from.ExportLinks("alice", func(info link.LinkInfo) error {
+		exported = append(exported, info)
+		return nil
+	})
+	exported = append(exported,
+		link.LinkInfo{Key: "broken", RealLink: "example.com/broken", PasswordHash: "secret"},
+		// checking a password against this hash would take hours
+		link.LinkInfo{Key: "costly", RealLink: "example.com/costly", PasswordHash: strings.Replace(exported[0].PasswordHash, "$10$", "$31$", 1)},
+	)
+
+	target := linkrepo.NewMemory()
+	target.CreateUserLinksStorage("bob")
+	to := LinkUseCases{LinkStorage: target}
+	results, err := to.ImportLinks("bob", exported)
+	if err != nil {
+		t.Fatalf("failed to import links: %v", err)
+	}
+	if results[0].Err != nil || results[1].Err != ErrInvalidPasswordHash || results[2].Err != ErrInvalidPasswordHash {
+		t.Fatalf("Only cheap bcrypt hashes MUST be imported, but %+v given", results)
+	}
+	if _, err := to.MakeRedirect("secret", Visit{}); err != ErrPasswordRequired {
+		t.Errorf("Imported link MUST stay protected, but %v given", err)
+	}
+	if realLink, err := to.MakeRedirect("secret", Visit{Password: "open sesame"}); err != nil || realLink != "example.com/secret" {
+		t.Errorf("Imported link MUST open with the old password, but %q, %v given", realLink, err)
+	}
+}
@@ -0,0 +1,82 @@
+package link
+
+import (
+	"errors"
+	"math/rand"
+)
+
+var (
+	ErrUnknownAlphabet   = errors.New("unknown key alphabet")
+	ErrInvalidKeyLength  = errors.New("invalid key length")
+	ErrKeySpaceExhausted = errors.New("failed to generate a free key")
+)
+
+const (
+	AlphabetLetters      = "letters"
+	AlphabetDigits       = "digits"
+	AlphabetLowercase    = "lowercase"
+	AlphabetAlphanumeric = "alphanumeric"
+	// AlphabetUnambiguous has no characters which are easy to mix up: 0/O/o, 1/l/I.
+	AlphabetUnambiguous = "unambiguous"
+)
+
+var alphabets = map[string]string{
+	AlphabetLetters:      "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	AlphabetDigits:       "0123456789",
+	AlphabetLowercase:    "abcdefghijklmnopqrstuvwxyz",
+	AlphabetAlphanumeric: "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	AlphabetUnambiguous:  "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ",
+}
+
+const (
+	minKeyLength = 4
+	maxKeyLength = 32
+)
+
+// DefaultKeyPolicy is applied when neither a request nor an account asks for another one.
+var DefaultKeyPolicy = KeyPolicy{Alphabet: AlphabetLetters, Length: 6}
+
+// KeyPolicy restricts characters and length of generated keys.
+type KeyPolicy struct {
+	Alphabet string
+	Length   int
+}
+
+func (p KeyPolicy) Validate() error {
+	if _, ok := alphabets[p.Alphabet]; !ok {
+		return ErrUnknownAlphabet
+	}
+	if p.Length < minKeyLength || p.Length > maxKeyLength {
+		return ErrInvalidKeyLength
+	}
+	return nil
+}
+
+// orDefault fills unset fields of the policy from def.
+func (p KeyPolicy) orDefault(def KeyPolicy) KeyPolicy {
+	if p.Alphabet == "" {
+		p.Alphabet = def.Alphabet
+	}
+	if p.Length == 0 {
+		p.Length = def.Length
+	}
+	return p
+}
+
+type KeyGenerator interface {
+	// GenerateKey returns a new key following the validated policy. The key
+	// is not guaranteed to be free.
+	GenerateKey(policy KeyPolicy) (string, error)
+}
+
+// RandomKeyGenerator picks every character of a key at random.
+type RandomKeyGenerator struct{}
+
+func (RandomKeyGenerator) GenerateKey(policy KeyPolicy) (string, error) {
+	letters := alphabets[policy.Alphabet]
+	b := make([]byte, policy.Length)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,110 @@
+package link
+
+import (
+	"koro.che/internal/interface/memory/linkrepo"
+	"strings"
+	"testing"
+)
+
+func Test_RandomKeyGenerator(t *testing.T) {
+	for name, letters := range alphabets {
+		t.Run(name, func(t *testing.T) {
+			policy := KeyPolicy{Alphabet: name, Length: 8}
+			key, err := RandomKeyGenerator{}.GenerateKey(policy)
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			if len(key) != policy.Length {
+				t.Errorf("Key MUST have length %d, but %q given", policy.Length, key)
+			}
+			for _, r := range key {
+				if !strings.ContainsRune(letters, r) {
+					t.Errorf("Key MUST consist of %s alphabet, but %q given", name, key)
+				}
+			}
+		})
+	}
+}
+
+func Test_unambiguousAlphabet(t *testing.T) {
+	if strings.ContainsAny(alphabets[AlphabetUnambiguous], "0Oo1lI") {
+		t.Errorf("Unambiguous alphabet MUST NOT contain look-alike characters")
+	}
+}
+
+func Test_KeyPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy KeyPolicy
+		err    error
+	}{
+		{DefaultKeyPolicy, nil},
+		{KeyPolicy{Alphabet: "emoji", Length: 6}, ErrUnknownAlphabet},
+		{KeyPolicy{Alphabet: AlphabetDigits, Length: minKeyLength - 1}, ErrInvalidKeyLength},
+		{KeyPolicy{Alphabet: AlphabetDigits, Length: maxKeyLength + 1}, ErrInvalidKeyLength},
+	}
+	for _, c := range cases {
+		if err := c.policy.Validate(); err != c.err {
+			t.Errorf("Policy %+v MUST give %v, but %v given", c.policy, c.err, err)
+		}
+	}
+}
+
+func Test_SetUserKeyPolicy(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	l := LinkUseCases{LinkStorage: storage}
+
+	cases := []struct {
+		policy   KeyPolicy
+		err      error
+		expected KeyPolicy
+	}{
+		{KeyPolicy{Alphabet: AlphabetDigits, Length: 8}, nil, KeyPolicy{Alphabet: AlphabetDigits, Length: 8}},
+		{KeyPolicy{Length: 10}, nil, KeyPolicy{Alphabet: DefaultKeyPolicy.Alphabet, Length: 10}},
+		{KeyPolicy{Alphabet: "emoji"}, ErrUnknownAlphabet, KeyPolicy{Alphabet: DefaultKeyPolicy.Alphabet, Length: 10}},
+		{KeyPolicy{}, nil, DefaultKeyPolicy},
+	}
+	for _, c := range cases {
+		if err := l.SetUserKeyPolicy("alice", c.policy); err != c.err {
+			t.Errorf("Policy %+v MUST give %v, but %v given", c.policy, c.err, err)
+		}
+		settings, err := l.GetUserSettings("alice")
+		if err != nil || settings.KeyPolicy != c.expected {
+			t.Errorf("After %+v policy MUST be %+v, but %+v (%v) given", c.policy, c.expected, settings.KeyPolicy, err)
+		}
+	}
+}
+
+func Test_SequenceKeyGenerator(t *testing.T) {
+	g := NewSequenceKeyGenerator(linkrepo.NewMemory(), []byte("secret"))
+	policy := KeyPolicy{Alphabet: AlphabetDigits, Length: 4}
+	seen := make(map[string]bool)
+	ordered := 0
+	previous := ""
+	// ids start from 1, so the whole key space is used by the ids below
+	for i := 0; i < 10000; i++ {
+		key, err := g.GenerateKey(policy)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		if len(key) != policy.Length || strings.Trim(key, alphabets[AlphabetDigits]) != "" {
+			t.Fatalf("Key MUST follow the policy, but %q given", key)
+		}
+		if seen[key] {
+			t.Fatalf("Key %q MUST NOT repeat within the key space", key)
+		}
+		seen[key] = true
+		if key > previous {
+			ordered++
+		}
+		previous = key
+	}
+	if ordered > 6000 {
+		t.Errorf("Keys MUST NOT follow the order of ids, but %d of 10000 are ordered", ordered)
+	}
+
+	key, err := g.GenerateKey(KeyPolicy{Alphabet: AlphabetAlphanumeric, Length: 32})
+	if err != nil || len(key) != 32 {
+		t.Errorf("Key of huge key space MUST be generated, but %q (%v) given", key, err)
+	}
+}
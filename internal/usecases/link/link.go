@@ -1,17 +1,110 @@
 package link
 
 import (
+	"errors"
 	"koro.che/internal/domain/link"
+	"strings"
+	"time"
 )
 
+var (
+	ErrLifetimeNotAllowed = errors.New("only registered users can choose link lifetime")
+	ErrLifetimeTooLong    = errors.New("link lifetime exceeds account limit")
+	ErrExpirationInPast   = errors.New("link expiration time is in the past")
+	ErrInvalidAliasString = errors.New("alias contains invalid character")
+	ErrTooShortAlias      = errors.New("too short alias")
+	ErrTooLongAlias       = errors.New("too long alias")
+	ErrReservedAlias      = errors.New("alias is reserved")
+	ErrUnknownSort        = errors.New("unknown sort order of links")
+	ErrEmptyDestination   = errors.New("link destination is empty")
+	ErrEmptyActiveWindow  = errors.New("link expires before it becomes active")
+	ErrBotOfLimitedLink   = errors.New("links with a click limit are not shown to bots")
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 32
+)
+
+// reservedAliases can't be used as keys since they clash with service routes
+// or may be used for them in future.
+var reservedAliases = map[string]bool{
+	"api":      true,
+	"metrics":  true,
+	"manage":   true,
+	"login":    true,
+	"logout":   true,
+	"register": true,
+	"admin":    true,
+	"static":   true,
+	"health":   true,
+}
+
 type LinkUseCasesInterface interface {
-	ShortenLink(link string, userId string) (string, error)
-	MakeRedirect(key string) (string, error)
+	ShortenLink(link string, userId string, opts ShortenOptions) (ShortenResult, error)
+	ShortenLinks(userId string, items []BatchItem) ([]BatchResult, error)
+	MakeRedirect(key string, visit Visit) (string, error)
 	DeleteLink(link string, userId string) (string, error)
-	GetRealLink(key string) (string, error)
-	GetUserLinks(userId string) ([]string, error)
-	GetLinkStats(key string) (LinkStat, error)
+	GetTrash(userId string) ([]link.LinkInfo, error)
+	RestoreLink(key string, userId string) error
+	UpdateLink(key string, userId string, realLink string) error
+	GetLinkHistory(key string, userId string) ([]link.DestinationChange, error)
+	GetRealLink(key string, password string) (string, error)
+	SetLinkPassword(key string, userId string, password string) error
+	GetUserLinks(userId string, query link.ListQuery) (link.LinkPage, error)
+	ExportLinks(userId string, fn func(info link.LinkInfo) error) error
+	ImportLinks(userId string, links []link.LinkInfo) ([]BatchResult, error)
+	GetLinkStats(key string, userId string) (LinkStat, error)
+	GetLinkTimeSeries(key string, userId string, query TimeSeriesQuery) (TimeSeries, error)
+	GetLinkBreakdown(key string, userId string, query BreakdownQuery) ([]link.ValueCount, error)
+	SetLinkExpiration(key string, userId string, lifetime Lifetime) (time.Time, error)
+	PauseLink(key string, userId string) error
+	ResumeLink(key string, userId string) error
 	CreateUserLinksStorage(userId string) (string, error)
+	GetUserSettings(userId string) (UserSettings, error)
+	SetUserKeyPolicy(userId string, policy KeyPolicy) error
+}
+
+// Lifetime describes when a link stops working. ExpiresAt takes precedence
+// over TTL, zero values of both ask for a link that never expires.
+type Lifetime struct {
+	ExpiresAt time.Time
+	TTL       time.Duration
+}
+
+func (lt Lifetime) IsZero() bool {
+	return lt.ExpiresAt.IsZero() && lt.TTL == 0
+}
+
+type ShortenOptions struct {
+	Lifetime
+	// Alias is a requested key of the link, a key is generated when empty.
+	Alias string
+	// KeyPolicy overrides the account defaults of the generated key.
+	KeyPolicy KeyPolicy
+	// ReuseExisting asks for an alive link of the same owner to the same
+	// destination instead of a new one. Only links without a password, a click
+	// limit or a start time are reused, and a link asked for with a click
+	// limit, a start time or Alias is always new. Lifetime and KeyPolicy apply
+	// only when a new link is made.
+	ReuseExisting bool
+	// MaxClicks makes the link stop working after the number of redirects, zero means unlimited.
+	MaxClicks uint64
+	// ActiveFrom schedules the start of the link, it works since creation when zero.
+	// The end is set by Lifetime.
+	ActiveFrom time.Time
+}
+
+type ShortenResult struct {
+	ShortLink string
+	// Reused is true when an existing link is returned.
+	Reused bool
+}
+
+// UserSettings are the saved link defaults and limits of an account.
+type UserSettings struct {
+	KeyPolicy KeyPolicy
+	MaxTTL    time.Duration
 }
 
 type LinkStat struct {
@@ -21,54 +114,403 @@ type LinkStat struct {
 
 type LinkUseCases struct{
 	LinkStorage link.Interface
+	// AnonymousTTL is the lifetime of links created without an account.
+	// DefaultAnonymousTTL is used when it is zero.
+	AnonymousTTL time.Duration
+	// MaxTTL is the default limit of lifetime of links created by registered
+	// users, zero means unlimited. Accounts may have their own limit in link.Settings.
+	MaxTTL time.Duration
+	// KeyGenerator makes keys of links without aliases, RandomKeyGenerator is used when nil.
+	KeyGenerator KeyGenerator
+	// Clicks records every redirect, clicks are not logged when nil.
+	Clicks *ClickRecorder
+	// Counters batches use counter updates, the storage counts every
+	// redirect at once when nil.
+	Counters *CounterBuffer
+	// PasswordAttempts limits wrong passwords of protected links, they are not limited when nil.
+	PasswordAttempts *AttemptLimiter
+	// Locator adds geography to clicks, it is optional.
+	Locator Locator
+	// VisitorSalt is mixed into visitor hashes, it must be secret and stay the
+	// same between restarts to count returning visitors once.
+	VisitorSalt []byte
 }
 
 // const prefix = "koro.che/"
 const prefix =  "localhost:8080/"
 
-func (l*LinkUseCases) ShortenLink(link string, userId string) (string, error) {
+const DefaultAnonymousTTL = 7 * 24 * time.Hour
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// maxKeyAttempts limits retries of key generation on collisions.
+const maxKeyAttempts = 10
+
+func (l*LinkUseCases) ShortenLink(realLink string, userId string, opts ShortenOptions) (ShortenResult, error) {
+	settings, err := l.shortenSettings(userId)
+	if err != nil {
+		return ShortenResult{}, err
+	}
+	newLink, policy, err := l.prepareLink(realLink, userId, settings, opts)
+	if err != nil {
+		return ShortenResult{}, err
+	}
+	if result, ok, err := l.reuseLink(realLink, userId, opts); ok || err != nil {
+		return result, err
+	}
 	var shortLink string
-	var err error
-	shortLink, err = l.LinkStorage.CreateShortLink(link, userId)
-	return prefix + shortLink, err
+	if opts.Alias != "" {
+		shortLink, err = l.createLink(userId, newLink)
+	} else {
+		shortLink, err = l.createWithGeneratedKey(userId, newLink, policy)
+	}
+	if err != nil {
+		return ShortenResult{}, err
+	}
+	return ShortenResult{ShortLink: prefix + shortLink}, nil
 }
 
-func (l*LinkUseCases) MakeRedirect(key string) (string, error)  {
-	var link string
-	var err error
-	link, err = l.LinkStorage.MakeRedirect(key)
-	return link, err
+// shortenSettings returns the account settings, anonymous links have none.
+func (l*LinkUseCases) shortenSettings(userId string) (link.Settings, error) {
+	if userId == "" {
+		return link.Settings{}, nil
+	}
+	return l.LinkStorage.GetUserSettings(userId)
+}
+
+// prepareLink checks the options of a new link. The link has the alias as its
+// key, the policy of its generated key is returned when there is no alias.
+func (l*LinkUseCases) prepareLink(realLink string, userId string, settings link.Settings, opts ShortenOptions) (link.NewLink, KeyPolicy, error) {
+	newLink := link.NewLink{RealLink: realLink, Key: opts.Alias, MaxClicks: opts.MaxClicks, ActiveFrom: opts.ActiveFrom}
+	if opts.Alias != "" {
+		if err := validateAlias(opts.Alias); err != nil {
+			return link.NewLink{}, KeyPolicy{}, err
+		}
+	}
+	if userId == "" {
+		if !opts.Lifetime.IsZero() || !opts.ActiveFrom.IsZero() {
+			return link.NewLink{}, KeyPolicy{}, ErrLifetimeNotAllowed
+		}
+		newLink.ExpiresAt = time.Now().Add(l.anonymousTTL())
+	} else {
+		var err error
+		newLink.ExpiresAt, err = l.expirationFor(settings, opts.Lifetime)
+		if err != nil {
+			return link.NewLink{}, KeyPolicy{}, err
+		}
+		if !newLink.ActiveFrom.IsZero() && link.Expired(newLink.ExpiresAt, newLink.ActiveFrom) {
+			return link.NewLink{}, KeyPolicy{}, ErrEmptyActiveWindow
+		}
+	}
+	var policy KeyPolicy
+	if opts.Alias == "" {
+		policy = opts.KeyPolicy.orDefault(savedKeyPolicy(settings)).orDefault(DefaultKeyPolicy)
+		if err := policy.Validate(); err != nil {
+			return link.NewLink{}, KeyPolicy{}, err
+		}
+	}
+	return newLink, policy, nil
+}
+
+// reuseLink finds the link to return instead of a new one in the reuse mode.
+func (l*LinkUseCases) reuseLink(realLink string, userId string, opts ShortenOptions) (ShortenResult, bool, error) {
+	if !opts.ReuseExisting || opts.Alias != "" || opts.MaxClicks != 0 || !opts.ActiveFrom.IsZero() {
+		return ShortenResult{}, false, nil
+	}
+	// anonymous links expire, so they are reused only within their lifetime
+	existing, err := l.LinkStorage.FindUserLink(userId, realLink)
+	if err == link.ErrNotExist {
+		return ShortenResult{}, false, nil
+	}
+	if err != nil {
+		return ShortenResult{}, false, err
+	}
+	return ShortenResult{ShortLink: prefix + existing.Key, Reused: true}, true, nil
+}
+
+func (l*LinkUseCases) createWithGeneratedKey(userId string, newLink link.NewLink, policy KeyPolicy) (string, error) {
+	for i := 0; i < maxKeyAttempts; i++ {
+		var err error
+		newLink.Key, err = l.keyGenerator().GenerateKey(policy)
+		if err != nil {
+			return "", err
+		}
+		key, err := l.createLink(userId, newLink)
+		if err != link.ErrAliasTaken {
+			return key, err
+		}
+	}
+	return "", ErrKeySpaceExhausted
+}
+
+// createLink stores the link with all its options in one step, so it is never
+// seen without them.
+func (l*LinkUseCases) createLink(userId string, newLink link.NewLink) (string, error) {
+	errs, err := l.LinkStorage.CreateShortLinks(userId, []link.NewLink{newLink})
+	if err != nil {
+		return "", err
+	}
+	if errs[0] != nil {
+		return "", errs[0]
+	}
+	return newLink.Key, nil
+}
+
+// MakeRedirect resolves the link for the visit. Only visits of people are
+// counted in the use counter, bots are logged as clicks with the flag.
+// Redirects of links with a click limit are counted at once by the storage,
+// bots get ErrBotOfLimitedLink instead so link previews and prefetching do
+// not use up the clicks.
+func (l*LinkUseCases) MakeRedirect(key string, visit Visit) (string, error)  {
+	info, err := l.resolve(key, visit.Password)
+	if err != nil {
+		return "", err
+	}
+	if info.MaxClicks != 0 && visit.IsBot() {
+		err = ErrBotOfLimitedLink
+	} else if info.MaxClicks != 0 {
+		if info.RealLink, err = l.LinkStorage.MakeRedirect(key); err != nil {
+			return "", err
+		}
+	} else if !visit.IsBot() {
+		if l.Counters != nil {
+			l.Counters.Add(key)
+		} else if err := l.LinkStorage.IncreaseUseCounters(map[string]uint64{key: 1}); err != nil {
+			return "", err
+		}
+	}
+	if l.Clicks != nil {
+		l.Clicks.Record(l.newClick(key, visit, time.Now()))
+	}
+	if err != nil {
+		return "", err
+	}
+	return info.RealLink, nil
+}
+
+// resolve returns the link if the password unlocks it.
+func (l*LinkUseCases) resolve(key string, password string) (link.LinkInfo, error) {
+	info, err := l.LinkStorage.GetLinkInfo(key)
+	if err != nil {
+		return link.LinkInfo{}, err
+	}
+	if info.PasswordHash != "" {
+		if err := l.unlock(key, info.PasswordHash, password); err != nil {
+			return link.LinkInfo{}, err
+		}
+	}
+	return info, nil
 }
 
 func (l*LinkUseCases) DeleteLink(link string, userId string) (string, error) {
+	if err := l.checkOwner(link, userId); err != nil {
+		return "", err
+	}
 	deleteLink, err := l.LinkStorage.DeleteLink(link, userId)
 	return deleteLink, err
 }
 
-func (l*LinkUseCases) GetRealLink(key string) (string, error) {
-	var link string
-	var err error
-	link, err = l.LinkStorage.GetLinkByKey(key)
-	return link, err
+// UpdateLink points the link to another destination, the key stays the same.
+func (l*LinkUseCases) UpdateLink(key string, userId string, realLink string) error {
+	if strings.TrimSpace(realLink) == "" {
+		return ErrEmptyDestination
+	}
+	if err := l.checkOwner(key, userId); err != nil {
+		return err
+	}
+	return l.LinkStorage.UpdateLink(key, userId, realLink)
 }
 
-func (l*LinkUseCases) GetUserLinks(userId string) ([]string, error) {
-	var links []string
-	var err error
-	links, err = l.LinkStorage.GetUserLinks(userId)
-	return links, err
+func (l*LinkUseCases) GetLinkHistory(key string, userId string) ([]link.DestinationChange, error) {
+	if err := l.checkOwner(key, userId); err != nil {
+		return nil, err
+	}
+	return l.LinkStorage.GetLinkHistory(key, userId)
+}
+
+func (l*LinkUseCases) GetRealLink(key string, password string) (string, error) {
+	info, err := l.resolve(key, password)
+	return info.RealLink, err
+}
+
+func (l*LinkUseCases) GetUserLinks(userId string, query link.ListQuery) (link.LinkPage, error) {
+	switch query.SortBy {
+	case "":
+		query.SortBy = link.SortByCreated
+	case link.SortByCreated, link.SortByClicks:
+	default:
+		return link.LinkPage{}, ErrUnknownSort
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit > maxPageSize {
+		query.Limit = maxPageSize
+	}
+	return l.LinkStorage.GetUserLinks(userId, query)
 }
 
-func (l*LinkUseCases) GetLinkStats(link string) (LinkStat, error) {
+func (l*LinkUseCases) GetLinkStats(link string, userId string) (LinkStat, error) {
 	var stat uint64
 	var err error
-	stat, err = l.LinkStorage.GetLinkStat(link)
+	if err = l.checkOwner(link, userId); err != nil {
+		return LinkStat{}, err
+	}
+	stat, err = l.LinkStorage.GetLinkStat(link, userId)
 	return LinkStat{link, stat}, err
 }
 
+func (l*LinkUseCases) SetLinkExpiration(key string, userId string, lifetime Lifetime) (time.Time, error) {
+	if err := l.checkOwner(key, userId); err != nil {
+		return time.Time{}, err
+	}
+	settings, err := l.LinkStorage.GetUserSettings(userId)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt, err := l.expirationFor(settings, lifetime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = l.LinkStorage.SetExpiration(key, userId, expiresAt)
+	return expiresAt, err
+}
+
+// PauseLink stops redirects of the link until ResumeLink, its key and stats are kept.
+func (l*LinkUseCases) PauseLink(key string, userId string) error {
+	return l.setPaused(key, userId, true)
+}
+
+func (l*LinkUseCases) ResumeLink(key string, userId string) error {
+	return l.setPaused(key, userId, false)
+}
+
+func (l*LinkUseCases) setPaused(key string, userId string, paused bool) error {
+	if err := l.checkOwner(key, userId); err != nil {
+		return err
+	}
+	return l.LinkStorage.SetPaused(key, userId, paused)
+}
+
 func (l*LinkUseCases) CreateUserLinksStorage(userId string) (string, error) {
 	var s string
 	var err error
 	s, err = l.LinkStorage.CreateUserLinksStorage(userId)
 	return s, err
-}
\ No newline at end of file
+}
+
+func (l*LinkUseCases) anonymousTTL() time.Duration {
+	if l.AnonymousTTL <= 0 {
+		return DefaultAnonymousTTL
+	}
+	return l.AnonymousTTL
+}
+
+func (l*LinkUseCases) GetUserSettings(userId string) (UserSettings, error) {
+	settings, err := l.LinkStorage.GetUserSettings(userId)
+	if err != nil {
+		return UserSettings{}, err
+	}
+	return UserSettings{
+		KeyPolicy: savedKeyPolicy(settings).orDefault(DefaultKeyPolicy),
+		MaxTTL:    l.maxTTL(settings),
+	}, nil
+}
+
+// SetUserKeyPolicy saves the account defaults of generated keys. Unset fields
+// fall back to DefaultKeyPolicy, so a zero policy clears the saved one.
+func (l*LinkUseCases) SetUserKeyPolicy(userId string, policy KeyPolicy) error {
+	if err := policy.orDefault(DefaultKeyPolicy).Validate(); err != nil {
+		return err
+	}
+	settings, err := l.LinkStorage.GetUserSettings(userId)
+	if err != nil {
+		return err
+	}
+	settings.Alphabet = policy.Alphabet
+	settings.KeyLength = policy.Length
+	return l.LinkStorage.SaveUserSettings(userId, settings)
+}
+
+// checkOwner makes sure that only the account which created the link acts on it.
+// Anonymous links have no owner, so nobody can manage them.
+func (l*LinkUseCases) checkOwner(key string, userId string) error {
+	owner, err := l.LinkStorage.GetLinkOwner(key)
+	if err != nil {
+		return err
+	}
+	if owner == "" || owner != userId {
+		return link.ErrForbidden
+	}
+	return nil
+}
+
+func (l*LinkUseCases) keyGenerator() KeyGenerator {
+	if l.KeyGenerator == nil {
+		return RandomKeyGenerator{}
+	}
+	return l.KeyGenerator
+}
+
+func savedKeyPolicy(settings link.Settings) KeyPolicy {
+	return KeyPolicy{Alphabet: settings.Alphabet, Length: settings.KeyLength}
+}
+
+func (l*LinkUseCases) maxTTL(settings link.Settings) time.Duration {
+	if settings.MaxTTL > 0 {
+		return settings.MaxTTL
+	}
+	return l.MaxTTL
+}
+
+// expirationFor resolves the requested lifetime of a link of a registered user
+// into the expiration time and checks it against the account limit.
+func (l*LinkUseCases) expirationFor(settings link.Settings, lifetime Lifetime) (time.Time, error) {
+	maxTTL := l.maxTTL(settings)
+
+	now := time.Now()
+	expiresAt := lifetime.ExpiresAt
+	if expiresAt.IsZero() && lifetime.TTL != 0 {
+		expiresAt = now.Add(lifetime.TTL)
+	}
+	if expiresAt.IsZero() {
+		if maxTTL > 0 {
+			expiresAt = now.Add(maxTTL)
+		}
+		return expiresAt, nil
+	}
+	if !expiresAt.After(now) {
+		return time.Time{}, ErrExpirationInPast
+	}
+	if maxTTL > 0 && expiresAt.Sub(now) > maxTTL {
+		return time.Time{}, ErrLifetimeTooLong
+	}
+	return expiresAt, nil
+}
+
+func validateAlias(alias string) error {
+	aliasLength := 0
+	for _, r := range alias {
+		if !isAliasRune(r) {
+			return ErrInvalidAliasString
+		}
+		aliasLength++
+	}
+	if aliasLength < minAliasLength {
+		return ErrTooShortAlias
+	}
+	if aliasLength > maxAliasLength {
+		return ErrTooLongAlias
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+func isAliasRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+}
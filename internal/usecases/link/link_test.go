@@ -0,0 +1,256 @@
+package link
+
+import (
+	"fmt"
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+func Test_ShortenLinkReuseExisting(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	storage.CreateUserLinksStorage("bob")
+	l := LinkUseCases{LinkStorage: storage}
+	reuse := ShortenOptions{ReuseExisting: true}
+
+	first, err := l.ShortenLink("Example.com/sale", "alice", reuse)
+	if err != nil || first.Reused {
+		t.Fatalf("First link MUST be new, but %+v (%v) given", first, err)
+	}
+	second, err := l.ShortenLink("https://example.com:443/sale", "alice", reuse)
+	if err != nil || !second.Reused || second.ShortLink != first.ShortLink {
+		t.Errorf("Link to the same destination MUST be reused, but %+v (%v) given", second, err)
+	}
+	if other, _ := l.ShortenLink("example.com/sale", "alice", ShortenOptions{}); other.Reused || other.ShortLink == first.ShortLink {
+		t.Errorf("Link MUST be new without reuse mode, but %+v given", other)
+	}
+	if other, _ := l.ShortenLink("example.com/sale", "bob", reuse); other.Reused {
+		t.Errorf("Links of other accounts MUST NOT be reused, but %+v given", other)
+	}
+	if anonymous, _ := l.ShortenLink("example.com/sale", "", reuse); anonymous.Reused {
+		t.Errorf("Links of accounts MUST NOT be reused anonymously, but %+v given", anonymous)
+	}
+
+	key := first.ShortLink[len(prefix):]
+	if err := storage.SetExpiration(key, "alice", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("failed to expire link: %v", err)
+	}
+	if fresh, _ := l.ShortenLink("example.com/sale", "alice", reuse); fresh.ShortLink == first.ShortLink {
+		t.Errorf("Expired link MUST NOT be reused, but %+v given", fresh)
+	}
+}
+
+func Test_ShortenLinkReuseOnlyPlain(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	l := LinkUseCases{LinkStorage: storage}
+	reuse := ShortenOptions{ReuseExisting: true}
+
+	cases := []struct {
+		name    string
+		userId  string
+		options ShortenOptions
+		// change makes the existing link special after its creation
+		change func(key string) error
+	}{
+		{"one-time link of another anonymous user", "", ShortenOptions{MaxClicks: 1}, nil},
+		{"scheduled link", "alice", ShortenOptions{ActiveFrom: time.Now().Add(-time.Minute)}, nil},
+		{"protected link", "alice", ShortenOptions{}, func(key string) error {
+			return l.SetLinkPassword(key, "alice", "open sesame")
+		}},
+		{"paused link", "alice", ShortenOptions{}, func(key string) error {
+			return l.PauseLink(key, "alice")
+		}},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			destination := fmt.Sprintf("example.com/%d", i)
+			existing, err := l.ShortenLink(destination, c.userId, c.options)
+			if err != nil {
+				t.Fatalf("failed to shorten link: %v", err)
+			}
+			if c.change != nil {
+				if err := c.change(existing.ShortLink[len(prefix):]); err != nil {
+					t.Fatalf("failed to change link: %v", err)
+				}
+			}
+			if plain, _ := l.ShortenLink(destination, c.userId, reuse); plain.Reused || plain.ShortLink == existing.ShortLink {
+				t.Errorf("Plain link MUST be new, but %+v given", plain)
+			}
+		})
+	}
+
+	plain, _ := l.ShortenLink("example.com/plain", "", reuse)
+	limited, _ := l.ShortenLink("example.com/plain", "", ShortenOptions{ReuseExisting: true, MaxClicks: 1})
+	if limited.Reused || limited.ShortLink == plain.ShortLink {
+		t.Errorf("Link with a click limit MUST be new, but %+v given", limited)
+	}
+}
+
+func Test_MakeRedirectMaxClicks(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	l := LinkUseCases{LinkStorage: storage, Counters: NewCounterBuffer(storage, 100, time.Hour)}
+	defer l.Counters.Close()
+
+	created, err := l.ShortenLink("example.com/invite", "alice", ShortenOptions{MaxClicks: 3})
+	if err != nil {
+		t.Fatalf("failed to shorten link: %v", err)
+	}
+	key := created.ShortLink[len(prefix):]
+
+	results := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := l.MakeRedirect(key, Visit{UserAgent: "Firefox"})
+			results <- err
+		}()
+	}
+	redirects := 0
+	for i := 0; i < 10; i++ {
+		if err := <-results; err == nil {
+			redirects++
+		} else if err != link.ErrExhausted {
+			t.Errorf("Redirect MUST succeed or be exhausted, but %v given", err)
+		}
+	}
+	if redirects != 3 {
+		t.Errorf("Link MUST redirect 3 times, but %d given", redirects)
+	}
+	if l.Counters.Pending() != 0 {
+		t.Errorf("Limited links MUST NOT be counted in the buffer, but %d pending", l.Counters.Pending())
+	}
+
+	page, err := l.GetUserLinks("alice", link.ListQuery{})
+	if err != nil || len(page.Links) != 1 || page.Links[0].Status(time.Now()) != link.StatusExhausted {
+		t.Errorf("Owner MUST see the exhausted link, but %+v (%v) given", page.Links, err)
+	}
+}
+
+func Test_MakeRedirectMaxClicksOfBots(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	l := LinkUseCases{LinkStorage: storage}
+
+	created, err := l.ShortenLink("example.com/invite", "alice", ShortenOptions{MaxClicks: 1})
+	if err != nil {
+		t.Fatalf("failed to shorten link: %v", err)
+	}
+	key := created.ShortLink[len(prefix):]
+
+	slackbot := Visit{UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}
+	if realLink, err := l.MakeRedirect(key, slackbot); err != ErrBotOfLimitedLink || realLink != "" {
+		t.Errorf("Bots MUST NOT get a limited link, but %q, %v given", realLink, err)
+	}
+	if realLink, err := l.MakeRedirect(key, Visit{UserAgent: "Firefox"}); err != nil || realLink != "example.com/invite" {
+		t.Errorf("Bots MUST NOT use up the clicks, but %q, %v given", realLink, err)
+	}
+}
+
+func Test_ShortenLinkActiveWindow(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	l := LinkUseCases{LinkStorage: storage}
+	start := time.Now().Add(time.Hour)
+
+	if _, err := l.ShortenLink("example.com/sale", "", ShortenOptions{ActiveFrom: start}); err != ErrLifetimeNotAllowed {
+		t.Errorf("Anonymous link MUST NOT be scheduled, but %v given", err)
+	}
+	empty := ShortenOptions{ActiveFrom: start, Lifetime: Lifetime{ExpiresAt: start}}
+	if _, err := l.ShortenLink("example.com/sale", "alice", empty); err != ErrEmptyActiveWindow {
+		t.Errorf("Link MUST NOT expire before its start, but %v given", err)
+	}
+	created, err := l.ShortenLink("example.com/sale", "alice", ShortenOptions{ActiveFrom: start})
+	if err != nil {
+		t.Fatalf("failed to shorten link: %v", err)
+	}
+	if _, err := l.MakeRedirect(created.ShortLink[len(prefix):], Visit{}); err != link.ErrNotActive {
+		t.Errorf("Scheduled link MUST NOT redirect before start, but %v given", err)
+	}
+}
+
+func Test_expirationFor(t *testing.T) {
+	l := LinkUseCases{MaxTTL: 24 * time.Hour}
+	now := time.Now()
+	cases := []struct {
+		name     string
+		settings link.Settings
+		lifetime Lifetime
+		// expected is the lifetime from now, zero for links which never expire
+		expected time.Duration
+		err      error
+	}{
+		{"service limit by default", link.Settings{}, Lifetime{}, 24 * time.Hour, nil},
+		{"ttl", link.Settings{}, Lifetime{TTL: time.Hour}, time.Hour, nil},
+		{"expiration time", link.Settings{}, Lifetime{ExpiresAt: now.Add(2 * time.Hour)}, 2 * time.Hour, nil},
+		{"expiration time over ttl", link.Settings{}, Lifetime{ExpiresAt: now.Add(2 * time.Hour), TTL: time.Hour}, 2 * time.Hour, nil},
+		{"ttl over service limit", link.Settings{}, Lifetime{TTL: 48 * time.Hour}, 0, ErrLifetimeTooLong},
+		{"account limit by default", link.Settings{MaxTTL: 72 * time.Hour}, Lifetime{}, 72 * time.Hour, nil},
+		{"account limit over service limit", link.Settings{MaxTTL: 72 * time.Hour}, Lifetime{TTL: 48 * time.Hour}, 48 * time.Hour, nil},
+		{"expiration time over account limit", link.Settings{MaxTTL: time.Hour}, Lifetime{ExpiresAt: now.Add(2 * time.Hour)}, 0, ErrLifetimeTooLong},
+		{"expiration time in the past", link.Settings{}, Lifetime{ExpiresAt: now.Add(-time.Minute)}, 0, ErrExpirationInPast},
+		{"negative ttl", link.Settings{}, Lifetime{TTL: -time.Minute}, 0, ErrExpirationInPast},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			expiresAt, err := l.expirationFor(c.settings, c.lifetime)
+			if err != c.err {
+				t.Fatalf("Lifetime %+v MUST give %v, but %v given", c.lifetime, c.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if d := expiresAt.Sub(now) - c.expected; d < -time.Second || d > time.Second {
+				t.Errorf("Link MUST live %v, but expires at %v", c.expected, expiresAt)
+			}
+		})
+	}
+
+	unlimited := LinkUseCases{}
+	if expiresAt, err := unlimited.expirationFor(link.Settings{}, Lifetime{}); err != nil || !expiresAt.IsZero() {
+		t.Errorf("Link MUST never expire without limits, but %v (%v) given", expiresAt, err)
+	}
+}
+
+func Test_SetLinkExpiration(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	storage.CreateUserLinksStorage("bob")
+	storage.SaveUserSettings("alice", link.Settings{MaxTTL: 48 * time.Hour})
+	l := LinkUseCases{LinkStorage: storage, MaxTTL: 24 * time.Hour}
+	created, err := l.ShortenLink("example.com/sale", "alice", ShortenOptions{})
+	if err != nil {
+		t.Fatalf("failed to shorten link: %v", err)
+	}
+	key := created.ShortLink[len(prefix):]
+
+	cases := []struct {
+		name     string
+		userId   string
+		lifetime Lifetime
+		err      error
+	}{
+		{"within account limit", "alice", Lifetime{TTL: 36 * time.Hour}, nil},
+		{"over account limit", "alice", Lifetime{TTL: 72 * time.Hour}, ErrLifetimeTooLong},
+		{"in the past", "alice", Lifetime{ExpiresAt: time.Now().Add(-time.Hour)}, ErrExpirationInPast},
+		{"foreign link", "bob", Lifetime{TTL: time.Hour}, link.ErrForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before, _ := storage.GetLinkInfo(key)
+			expiresAt, err := l.SetLinkExpiration(key, c.userId, c.lifetime)
+			if err != c.err {
+				t.Fatalf("Lifetime %+v MUST give %v, but %v given", c.lifetime, c.err, err)
+			}
+			after, _ := storage.GetLinkInfo(key)
+			switch {
+			case err == nil && !after.ExpiresAt.Equal(expiresAt):
+				t.Errorf("Link MUST expire at %v, but %v given", expiresAt, after.ExpiresAt)
+			case err != nil && !after.ExpiresAt.Equal(before.ExpiresAt):
+				t.Errorf("Failed change MUST keep expiration %v, but %v given", before.ExpiresAt, after.ExpiresAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package link
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPasswordRequired    = errors.New("link is protected with a password")
+	ErrWrongPassword       = errors.New("wrong link password")
+	ErrTooManyAttempts     = errors.New("too many wrong passwords, try again later")
+	ErrInvalidLinkPassword = errors.New("link password must be 4 to 72 bytes long")
+	ErrInvalidPasswordHash = errors.New("link password hash must be a bcrypt hash of cost up to 10")
+)
+
+const (
+	// maxPasswordHashCost keeps imported hashes as cheap to check as the
+	// ones made by SetLinkPassword, costly ones would let every guess take minutes.
+	maxPasswordHashCost   = bcrypt.DefaultCost
+	minLinkPasswordLength = 4
+	// bcrypt ignores bytes after the 72nd
+	maxLinkPasswordLength = 72
+)
+
+// AttemptLimiter allows a number of failed attempts per key in a time window.
+type AttemptLimiter struct {
+	maxFailures int
+	window      time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*failureWindow
+}
+
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+func NewAttemptLimiter(maxFailures int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		maxFailures: maxFailures,
+		window:      window,
+		failures:    make(map[string]*failureWindow),
+	}
+}
+
+// Allowed reports whether the key has failed attempts left in the current window.
+func (a *AttemptLimiter) Allowed(key string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.failures[key]
+	if !ok || now.Sub(w.start) >= a.window {
+		return true
+	}
+	return w.count < a.maxFailures
+}
+
+func (a *AttemptLimiter) Fail(key string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.failures[key]
+	if !ok || now.Sub(w.start) >= a.window {
+		a.dropOld(now)
+		w = &failureWindow{start: now}
+		a.failures[key] = w
+	}
+	w.count++
+}
+
+// dropOld forgets windows which are over, a.mu must be held.
+func (a *AttemptLimiter) dropOld(now time.Time) {
+	for key, w := range a.failures {
+		if now.Sub(w.start) >= a.window {
+			delete(a.failures, key)
+		}
+	}
+}
+
+// SetLinkPassword protects the link with the password, empty password removes the protection.
+func (l *LinkUseCases) SetLinkPassword(key string, userId string, password string) error {
+	var hash string
+	if password != "" {
+		if len(password) < minLinkPasswordLength || len(password) > maxLinkPasswordLength {
+			return ErrInvalidLinkPassword
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hash = string(hashedPassword)
+	}
+	if err := l.checkOwner(key, userId); err != nil {
+		return err
+	}
+	return l.LinkStorage.SetPasswordHash(key, userId, hash)
+}
+
+// validPasswordHash accepts bcrypt hashes which are not costlier than the ones
+// made by SetLinkPassword.
+func validPasswordHash(passwordHash string) bool {
+	cost, err := bcrypt.Cost([]byte(passwordHash))
+	return err == nil && cost <= maxPasswordHashCost
+}
+
+// unlock checks the password of a protected link, failures are limited per link.
+func (l *LinkUseCases) unlock(key string, passwordHash string, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	now := time.Now()
+	if l.PasswordAttempts != nil && !l.PasswordAttempts.Allowed(key, now) {
+		return ErrTooManyAttempts
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		if l.PasswordAttempts != nil {
+			l.PasswordAttempts.Fail(key, now)
+		}
+		return ErrWrongPassword
+	}
+	return nil
+}
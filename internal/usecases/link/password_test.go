@@ -0,0 +1,68 @@
+package link
+
+import (
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+const browser = "Firefox"
+
+func Test_ProtectedRedirect(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	l := LinkUseCases{LinkStorage: storage, PasswordAttempts: NewAttemptLimiter(2, time.Minute)}
+
+	created, err := l.ShortenLink("example.com/secret", "alice", ShortenOptions{})
+	if err != nil {
+		t.Fatalf("failed to shorten link: %v", err)
+	}
+	key := created.ShortLink[len(prefix):]
+	if err := l.SetLinkPassword(key, "alice", "abc"); err != ErrInvalidLinkPassword {
+		t.Errorf("Short password MUST be rejected, but %v given", err)
+	}
+	if err := l.SetLinkPassword(key, "alice", "open sesame"); err != nil {
+		t.Fatalf("failed to set password: %v", err)
+	}
+
+	if _, err := l.MakeRedirect(key, Visit{}); err != ErrPasswordRequired {
+		t.Errorf("Redirect without password MUST fail with %v, but %v given", ErrPasswordRequired, err)
+	}
+	if _, err := l.MakeRedirect(key, Visit{Password: "guess"}); err != ErrWrongPassword {
+		t.Errorf("Redirect with wrong password MUST fail with %v, but %v given", ErrWrongPassword, err)
+	}
+	if realLink, err := l.MakeRedirect(key, Visit{Password: "open sesame", UserAgent: browser}); err != nil || realLink != "example.com/secret" {
+		t.Errorf("Redirect with right password MUST succeed, but %q (%v) given", realLink, err)
+	}
+	if info, _ := storage.GetLinkInfo(key); info.UseCounter != 1 {
+		t.Errorf("Only unlocked redirects MUST be counted, but counter is %d", info.UseCounter)
+	}
+
+	l.MakeRedirect(key, Visit{Password: "guess again"})
+	if _, err := l.MakeRedirect(key, Visit{Password: "open sesame"}); err != ErrTooManyAttempts {
+		t.Errorf("Attempts MUST be limited after failures, but %v given", err)
+	}
+
+	if err := l.SetLinkPassword(key, "alice", ""); err != nil {
+		t.Fatalf("failed to remove password: %v", err)
+	}
+	if _, err := l.MakeRedirect(key, Visit{}); err != nil {
+		t.Errorf("Link without password MUST redirect, but %v given", err)
+	}
+}
+
+func Test_AttemptLimiterWindow(t *testing.T) {
+	limiter := NewAttemptLimiter(1, time.Minute)
+	now := time.Now()
+
+	limiter.Fail("key", now)
+	if limiter.Allowed("key", now.Add(time.Second)) {
+		t.Error("Key MUST be blocked within the window")
+	}
+	if !limiter.Allowed("other", now) {
+		t.Error("Other keys MUST NOT be blocked")
+	}
+	if !limiter.Allowed("key", now.Add(time.Minute)) {
+		t.Error("Key MUST be allowed after the window")
+	}
+}
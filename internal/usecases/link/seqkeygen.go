@@ -0,0 +1,112 @@
+package link
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"koro.che/internal/domain/link"
+	"math/bits"
+	"sync"
+)
+
+// feistelRounds is enough for the permutation to look random without the secret.
+const feistelRounds = 4
+
+// SequenceKeyGenerator turns ids reserved in the storage into keys. Ids are
+// shuffled over all the keys of the policy by a permutation keyed with the
+// secret, so consecutive links don't get consecutive keys. Keys of the same
+// policy don't collide until every key of it is used once, then ids wrap around.
+type SequenceKeyGenerator struct {
+	storage link.Interface
+	secret  []byte
+
+	mu     sync.Mutex
+	nextId uint64
+	lastId uint64
+}
+
+func NewSequenceKeyGenerator(storage link.Interface, secret []byte) *SequenceKeyGenerator {
+	return &SequenceKeyGenerator{storage: storage, secret: secret}
+}
+
+func (g *SequenceKeyGenerator) GenerateKey(policy KeyPolicy) (string, error) {
+	id, err := g.reserveId()
+	if err != nil {
+		return "", err
+	}
+	letters := alphabets[policy.Alphabet]
+	size, full := keySpaceSize(len(letters), policy.Length)
+	if !full {
+		id %= size
+	}
+	n := g.permute(id, size, full)
+	b := make([]byte, policy.Length)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = letters[n%uint64(len(letters))]
+		n /= uint64(len(letters))
+	}
+	return string(b), nil
+}
+
+func (g *SequenceKeyGenerator) reserveId() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.nextId == g.lastId {
+		first, err := g.storage.ReserveKeyIds()
+		if err != nil {
+			return 0, err
+		}
+		g.nextId, g.lastId = first, first+link.KeyIdBlockSize
+	}
+	id := g.nextId
+	g.nextId++
+	return id, nil
+}
+
+// keySpaceSize returns the number of keys of the length, full is true when
+// it doesn't fit into uint64 and keys are made of all the 64-bit numbers.
+func keySpaceSize(letters int, length int) (size uint64, full bool) {
+	size = 1
+	for i := 0; i < length; i++ {
+		hi, lo := bits.Mul64(size, uint64(letters))
+		if hi != 0 {
+			return 0, true
+		}
+		size = lo
+	}
+	return size, false
+}
+
+// permute is a bijection of [0, size). The Feistel network permutes numbers
+// of an even number of bits, those out of range are permuted again until they
+// come into it.
+func (g *SequenceKeyGenerator) permute(n uint64, size uint64, full bool) uint64 {
+	halfBits := 32
+	if !full {
+		halfBits = (bits.Len64(size-1) + 1) / 2
+	}
+	for {
+		n = g.feistel(n, halfBits)
+		if full || n < size {
+			return n
+		}
+	}
+}
+
+func (g *SequenceKeyGenerator) feistel(n uint64, halfBits int) uint64 {
+	mask := uint64(1)<<halfBits - 1
+	left, right := n>>halfBits&mask, n&mask
+	for round := byte(0); round < feistelRounds; round++ {
+		left, right = right, left^g.roundFunction(round, right)&mask
+	}
+	return left<<halfBits | right
+}
+
+func (g *SequenceKeyGenerator) roundFunction(round byte, half uint64) uint64 {
+	mac := hmac.New(sha256.New, g.secret)
+	var msg [9]byte
+	msg[0] = round
+	binary.BigEndian.PutUint64(msg[1:], half)
+	mac.Write(msg[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
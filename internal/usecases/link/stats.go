@@ -0,0 +1,204 @@
+package link
+
+import (
+	"errors"
+	"koro.che/internal/domain/link"
+	"koro.che/internal/hll"
+	"time"
+)
+
+var (
+	ErrUnknownInterval  = errors.New("unknown stats interval")
+	ErrUnknownTimeZone  = errors.New("unknown time zone")
+	ErrInvalidTimeRange = errors.New("invalid stats time range")
+	ErrTooManyBuckets   = errors.New("too many stats buckets")
+	ErrUnknownDimension = errors.New("unknown stats dimension")
+	ErrUnknownBotFilter = errors.New("unknown bot filter")
+)
+
+const (
+	maxBuckets        = 1000
+	defaultStatsRange = 7 * 24 * time.Hour
+	defaultTopSize    = 10
+	maxTopSize        = 100
+)
+
+// TimeSeriesQuery asks for clicks of a link in [From, To) bucketed by Interval
+// in the TimeZone. Zero From and To select the last week, empty Interval means
+// days and empty TimeZone means UTC. Bots is one of link.BotsExclude (default),
+// link.BotsInclude and link.BotsOnly.
+type TimeSeriesQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+	TimeZone string
+	Bots     string
+}
+
+// TimeSeries has clicks and approximate numbers of unique visitors per bucket.
+// Visitors are counted per hour in UTC, so buckets of time zones with offsets
+// of not whole hours count visitors of up to half an hour nearby. Only people
+// are counted as visitors, they are not counted for link.BotsOnly.
+type TimeSeries struct {
+	Key      string
+	Interval string
+	Location *time.Location
+	Buckets  []link.ClickBucket
+	Total    uint64
+	Visitors uint64
+}
+
+func (l *LinkUseCases) GetLinkTimeSeries(key string, userId string, q TimeSeriesQuery) (TimeSeries, error) {
+	if err := l.checkOwner(key, userId); err != nil {
+		return TimeSeries{}, err
+	}
+	query, err := clickQuery(key, q)
+	if err != nil {
+		return TimeSeries{}, err
+	}
+	buckets, err := l.LinkStorage.CountClicks(query)
+	if err != nil {
+		return TimeSeries{}, err
+	}
+	ts := fillBuckets(query, buckets)
+	if query.Bots == link.BotsOnly {
+		return ts, nil
+	}
+	sketches, err := l.LinkStorage.GetVisitorSketches(key, query.From, query.To)
+	if err != nil {
+		return TimeSeries{}, err
+	}
+	countVisitors(&ts, sketches)
+	return ts, nil
+}
+
+func clickQuery(key string, q TimeSeriesQuery) (link.ClickQuery, error) {
+	query := link.ClickQuery{Key: key, Interval: q.Interval, From: q.From, To: q.To}
+	bots, err := botFilter(q.Bots)
+	if err != nil {
+		return link.ClickQuery{}, err
+	}
+	query.Bots = bots
+	switch query.Interval {
+	case "":
+		query.Interval = link.IntervalDay
+	case link.IntervalHour, link.IntervalDay, link.IntervalWeek:
+	default:
+		return link.ClickQuery{}, ErrUnknownInterval
+	}
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil || loc == time.Local {
+		return link.ClickQuery{}, ErrUnknownTimeZone
+	}
+	query.Location = loc
+
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultStatsRange)
+	}
+	if !query.From.Before(query.To) {
+		return link.ClickQuery{}, ErrInvalidTimeRange
+	}
+	// buckets are whole, so the range starts at the beginning of the first one
+	query.From = link.TruncateTime(query.From, query.Interval, loc)
+
+	n := 0
+	for start := query.From; start.Before(query.To); start = link.NextBucket(start, query.Interval, loc) {
+		if n++; n > maxBuckets {
+			return link.ClickQuery{}, ErrTooManyBuckets
+		}
+	}
+	return query, nil
+}
+
+func botFilter(bots string) (string, error) {
+	switch bots {
+	case "":
+		return link.BotsExclude, nil
+	case link.BotsExclude, link.BotsInclude, link.BotsOnly:
+		return bots, nil
+	default:
+		return "", ErrUnknownBotFilter
+	}
+}
+
+// countVisitors merges hourly sketches into sketches of the buckets and of the whole range.
+func countVisitors(ts *TimeSeries, sketches []link.VisitorSketch) {
+	total := hll.New(hll.DefaultPrecision)
+	perBucket := make(map[int64]*hll.Sketch)
+	for _, vs := range sketches {
+		start := link.TruncateTime(vs.Hour, ts.Interval, ts.Location).Unix()
+		s, ok := perBucket[start]
+		if !ok {
+			s = hll.New(vs.Sketch.Precision())
+			perBucket[start] = s
+		}
+		s.Merge(vs.Sketch)
+		total.Merge(vs.Sketch)
+	}
+	for i := range ts.Buckets {
+		if s, ok := perBucket[ts.Buckets[i].Start.Unix()]; ok {
+			ts.Buckets[i].Visitors = s.Estimate()
+		}
+	}
+	ts.Visitors = total.Estimate()
+}
+
+// fillBuckets adds empty buckets missing in the storage answer.
+func fillBuckets(query link.ClickQuery, buckets []link.ClickBucket) TimeSeries {
+	counts := make(map[int64]uint64, len(buckets))
+	for _, b := range buckets {
+		counts[b.Start.Unix()] += b.Clicks
+	}
+	ts := TimeSeries{Key: query.Key, Interval: query.Interval, Location: query.Location}
+	for start := query.From; start.Before(query.To); start = link.NextBucket(start, query.Interval, query.Location) {
+		n := counts[start.Unix()]
+		ts.Buckets = append(ts.Buckets, link.ClickBucket{Start: start, Clicks: n})
+		ts.Total += n
+	}
+	return ts
+}
+
+// BreakdownQuery asks for the Limit most popular values of the Dimension among
+// clicks in [From, To). Zero From and To mean all the clicks so far. Bots
+// filters clicks like in TimeSeriesQuery.
+type BreakdownQuery struct {
+	Dimension string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Bots      string
+}
+
+func (l *LinkUseCases) GetLinkBreakdown(key string, userId string, q BreakdownQuery) ([]link.ValueCount, error) {
+	if err := l.checkOwner(key, userId); err != nil {
+		return nil, err
+	}
+	query := link.BreakdownQuery{Key: key, Dimension: q.Dimension, From: q.From, To: q.To, Limit: q.Limit}
+	bots, err := botFilter(q.Bots)
+	if err != nil {
+		return nil, err
+	}
+	query.Bots = bots
+	switch query.Dimension {
+	case link.DimensionBrowser, link.DimensionOS, link.DimensionDevice, link.DimensionReferrer,
+		link.DimensionCountry, link.DimensionRegion, link.DimensionCity:
+	default:
+		return nil, ErrUnknownDimension
+	}
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if !query.From.Before(query.To) {
+		return nil, ErrInvalidTimeRange
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultTopSize
+	}
+	if query.Limit > maxTopSize {
+		query.Limit = maxTopSize
+	}
+	return l.LinkStorage.TopClickValues(query)
+}
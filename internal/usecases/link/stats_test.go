@@ -0,0 +1,130 @@
+package link
+
+import (
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+func Test_GetLinkTimeSeries(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	if _, err := link.CreateShortLink(storage, "example.com", "alice", "sale", time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	// all the clicks are made by the same visitor
+	clicks := []link.Click{
+		// 2021-05-01 in Moscow
+		{Key: "sale", At: time.Date(2021, 4, 30, 22, 0, 0, 0, time.UTC)},
+		{Key: "sale", At: time.Date(2021, 5, 1, 20, 59, 0, 0, time.UTC)},
+		// 2021-05-03 in Moscow
+		{Key: "sale", At: time.Date(2021, 5, 2, 21, 0, 0, 0, time.UTC)},
+		// out of range
+		{Key: "sale", At: time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)},
+	}
+	storage.SaveClicks(clicks)
+	storage.SaveVisitorSketches(visitorSketches(clicks))
+	l := LinkUseCases{LinkStorage: storage}
+
+	t.Run("daily buckets with empty days", func(t *testing.T) {
+		ts, err := l.GetLinkTimeSeries("sale", "alice", TimeSeriesQuery{
+			From:     time.Date(2021, 5, 1, 0, 0, 0, 0, moscow),
+			To:       time.Date(2021, 5, 4, 0, 0, 0, 0, moscow),
+			TimeZone: "Europe/Moscow",
+		})
+		if err != nil {
+			t.Fatalf("failed to get time series: %v", err)
+		}
+		expected := []uint64{2, 0, 1}
+		if len(ts.Buckets) != len(expected) {
+			t.Fatalf("Time series MUST have %d buckets, but %v given", len(expected), ts.Buckets)
+		}
+		for i, b := range ts.Buckets {
+			if b.Clicks != expected[i] {
+				t.Errorf("Bucket %v MUST have %d clicks, but %d given", b.Start, expected[i], b.Clicks)
+			}
+		}
+		if ts.Total != 3 {
+			t.Errorf("Total MUST be 3, but %d given", ts.Total)
+		}
+		expectedVisitors := []uint64{1, 0, 1}
+		for i, b := range ts.Buckets {
+			if b.Visitors != expectedVisitors[i] {
+				t.Errorf("Bucket %v MUST have %d visitors, but %d given", b.Start, expectedVisitors[i], b.Visitors)
+			}
+		}
+		if ts.Visitors != 1 {
+			t.Errorf("Unique visitors MUST be 1, but %d given", ts.Visitors)
+		}
+	})
+	t.Run("weeks start on monday", func(t *testing.T) {
+		ts, err := l.GetLinkTimeSeries("sale", "alice", TimeSeriesQuery{
+			From:     time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+			To:       time.Date(2021, 5, 4, 0, 0, 0, 0, time.UTC),
+			Interval: link.IntervalWeek,
+		})
+		if err != nil {
+			t.Fatalf("failed to get time series: %v", err)
+		}
+		if len(ts.Buckets) != 2 || !ts.Buckets[0].Start.Equal(time.Date(2021, 4, 26, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Time series MUST start on Monday 2021-04-26, but %v given", ts.Buckets)
+		}
+	})
+	t.Run("foreign link", func(t *testing.T) {
+		if _, err := l.GetLinkTimeSeries("sale", "bob", TimeSeriesQuery{}); err != link.ErrForbidden {
+			t.Errorf("Use case MUST return %v, but %v given", link.ErrForbidden, err)
+		}
+	})
+	t.Run("unknown interval", func(t *testing.T) {
+		if _, err := l.GetLinkTimeSeries("sale", "alice", TimeSeriesQuery{Interval: "year"}); err != ErrUnknownInterval {
+			t.Errorf("Use case MUST return %v, but %v given", ErrUnknownInterval, err)
+		}
+	})
+}
+
+func Test_GetLinkBreakdown(t *testing.T) {
+	storage := linkrepo.NewMemory()
+	storage.CreateUserLinksStorage("alice")
+	if _, err := link.CreateShortLink(storage, "example.com", "alice", "sale", time.Time{}); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	l := LinkUseCases{LinkStorage: storage}
+	now := time.Now()
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0"
+	storage.SaveClicks([]link.Click{
+		l.newClick("sale", Visit{Referrer: "https://t.me/sales", UserAgent: firefox}, now.Add(-time.Hour)),
+		l.newClick("sale", Visit{Referrer: "https://www.google.com/", UserAgent: firefox}, now.Add(-time.Hour)),
+		l.newClick("sale", Visit{Referrer: "https://google.com/search", UserAgent: firefox}, now.Add(-time.Hour)),
+		l.newClick("sale", Visit{UserAgent: firefox}, now.Add(-time.Hour)),
+		l.newClick("sale", Visit{Referrer: "https://t.me/sales", UserAgent: "TelegramBot (like TwitterBot)"}, now.Add(-time.Hour)),
+		l.newClick("sale", Visit{Referrer: "https://t.me/sales", UserAgent: firefox, Prefetch: true}, now.Add(-time.Hour)),
+	})
+
+	values, err := l.GetLinkBreakdown("sale", "alice", BreakdownQuery{Dimension: link.DimensionReferrer, Limit: 2})
+	if err != nil {
+		t.Fatalf("failed to get breakdown: %v", err)
+	}
+	expected := []link.ValueCount{{Value: "google.com", Clicks: 2}, {Value: "", Clicks: 1}}
+	if len(values) != len(expected) || values[0] != expected[0] || values[1] != expected[1] {
+		t.Errorf("Breakdown MUST be %v, but %v given", expected, values)
+	}
+	if _, err := l.GetLinkBreakdown("sale", "alice", BreakdownQuery{Dimension: "color"}); err != ErrUnknownDimension {
+		t.Errorf("Use case MUST return %v, but %v given", ErrUnknownDimension, err)
+	}
+
+	bots, err := l.GetLinkBreakdown("sale", "alice", BreakdownQuery{Dimension: link.DimensionReferrer, Bots: link.BotsOnly})
+	if err != nil {
+		t.Fatalf("failed to get breakdown: %v", err)
+	}
+	if len(bots) != 1 || bots[0] != (link.ValueCount{Value: "t.me", Clicks: 2}) {
+		t.Errorf("Breakdown of bots MUST be only t.me with 2 clicks, but %v given", bots)
+	}
+	if _, err := l.GetLinkBreakdown("sale", "alice", BreakdownQuery{Dimension: link.DimensionReferrer, Bots: "some"}); err != ErrUnknownBotFilter {
+		t.Errorf("Use case MUST return %v, but %v given", ErrUnknownBotFilter, err)
+	}
+}
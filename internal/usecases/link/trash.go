@@ -0,0 +1,78 @@
+package link
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"koro.che/internal/domain/link"
+	"sync"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted links may be restored.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+func (l *LinkUseCases) GetTrash(userId string) ([]link.LinkInfo, error) {
+	return l.LinkStorage.GetTrash(userId)
+}
+
+// RestoreLink brings a deleted link back with its key and stats.
+func (l *LinkUseCases) RestoreLink(key string, userId string) error {
+	return l.LinkStorage.RestoreLink(key, userId)
+}
+
+// TrashPurger drops links which have been in the trash longer than the retention.
+type TrashPurger struct {
+	storage   link.Interface
+	retention time.Duration
+	interval  time.Duration
+	logger    zerolog.Logger
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTrashPurger starts purging the trash every interval.
+func NewTrashPurger(storage link.Interface, retention time.Duration, interval time.Duration) *TrashPurger {
+	p := &TrashPurger{
+		storage:   storage,
+		retention: retention,
+		interval:  interval,
+		logger:    log.With().Str("module", "trash-purger").Logger(),
+		done:      make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Close stops the purger, a purge in progress is finished first.
+func (p *TrashPurger) Close() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *TrashPurger) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Purge(time.Now())
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge drops links deleted before now minus the retention.
+func (p *TrashPurger) Purge(now time.Time) {
+	n, err := p.storage.PurgeTrash(now.Add(-p.retention))
+	if err != nil {
+		p.logger.Error().Err(err).Msg("failed to purge trash, retrying later")
+		return
+	}
+	if n > 0 {
+		p.logger.Info().Int("links", n).Msg("purged trash")
+	}
+}
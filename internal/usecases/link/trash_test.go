@@ -0,0 +1,38 @@
+package link
+
+import (
+	"koro.che/internal/domain/link"
+	"koro.che/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+func Test_TrashPurger(t *testing.T) {
+	now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	storage := linkrepo.NewMemory().WithClock(func() time.Time { return now })
+	storage.CreateUserLinksStorage("alice")
+	storage.CreateUserLinksStorage("bob")
+	l := LinkUseCases{LinkStorage: storage}
+	for _, alias := range []string{"old", "new"} {
+		if _, err := l.ShortenLink("example.com/"+alias, "alice", ShortenOptions{Alias: alias}); err != nil {
+			t.Fatalf("failed to shorten link: %v", err)
+		}
+	}
+	l.DeleteLink("old", "alice")
+	now = now.Add(time.Hour)
+	l.DeleteLink("new", "alice")
+
+	purger := &TrashPurger{storage: storage, retention: 90 * time.Minute}
+	purger.Purge(now.Add(time.Hour))
+
+	trash, _ := l.GetTrash("alice")
+	if len(trash) != 1 || trash[0].Key != "new" {
+		t.Errorf("Only links deleted before the retention MUST be purged, but %+v left", trash)
+	}
+	if _, err := l.ShortenLink("evil.com", "bob", ShortenOptions{Alias: "old"}); err != link.ErrAliasTaken {
+		t.Errorf("Key of purged link MUST NOT be reissued, but %v given", err)
+	}
+	if err := l.RestoreLink("new", "alice"); err != nil {
+		t.Errorf("Link within the retention MUST be restored, but %v given", err)
+	}
+}
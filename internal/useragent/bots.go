@@ -0,0 +1,39 @@
+package useragent
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed bots.txt
+var botList string
+
+var botSignatures = parseSignatures(botList)
+
+func parseSignatures(list string) []string {
+	var signatures []string
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signatures = append(signatures, line)
+	}
+	return signatures
+}
+
+// IsBot reports whether the User-Agent belongs to a crawler, a link preview
+// fetcher or a script. Browsers always send User-Agent, so clients without
+// it are bots too.
+func IsBot(ua string) bool {
+	if strings.TrimSpace(ua) == "" {
+		return true
+	}
+	ua = strings.ToLower(ua)
+	for _, s := range botSignatures {
+		if strings.Contains(ua, s) {
+			return true
+		}
+	}
+	return false
+}
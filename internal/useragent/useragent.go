@@ -0,0 +1,74 @@
+// Package useragent classifies clients by their User-Agent header.
+package useragent
+
+import "strings"
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	Unknown       = "unknown"
+)
+
+type Info struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+type rule struct {
+	name    string
+	markers []string
+}
+
+// browsers are checked in order: many browsers also mention the engines
+// of others, e.g. every Chromium based browser says it is Chrome and Safari.
+var browsers = []rule{
+	{"Edge", []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}},
+	{"Opera", []string{"OPR/", "Opera"}},
+	{"Yandex Browser", []string{"YaBrowser/"}},
+	{"Samsung Internet", []string{"SamsungBrowser/"}},
+	{"Firefox", []string{"Firefox/", "FxiOS/"}},
+	{"Chrome", []string{"Chrome/", "CriOS/"}},
+	{"Safari", []string{"Safari/"}},
+	{"Internet Explorer", []string{"MSIE ", "Trident/"}},
+}
+
+var systems = []rule{
+	{"Windows", []string{"Windows"}},
+	{"Android", []string{"Android"}},
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"macOS", []string{"Macintosh", "Mac OS X"}},
+	{"Chrome OS", []string{"CrOS"}},
+	{"Linux", []string{"Linux"}},
+}
+
+func Parse(ua string) Info {
+	if ua == "" {
+		return Info{Browser: Unknown, OS: Unknown, Device: Unknown}
+	}
+	info := Info{
+		Browser: match(browsers, ua),
+		OS:      match(systems, ua),
+		Device:  DeviceDesktop,
+	}
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		info.OS == "Android" && !strings.Contains(ua, "Mobile"):
+		info.Device = DeviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		info.Device = DeviceMobile
+	}
+	return info
+}
+
+func match(rules []rule, ua string) string {
+	for _, r := range rules {
+		for _, m := range r.markers {
+			if strings.Contains(ua, m) {
+				return r.name
+			}
+		}
+	}
+	return "Other"
+}
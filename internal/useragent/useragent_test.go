@@ -0,0 +1,76 @@
+package useragent
+
+import "testing"
+
+func Test_Parse(t *testing.T) {
+	cases := []struct {
+		ua       string
+		expected Info
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36",
+			Info{"Chrome", "Windows", DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36 Edg/90.0.818.56",
+			Info{"Edge", "Windows", DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 14_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1 Mobile/15E148 Safari/604.1",
+			Info{"Safari", "iOS", DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 14_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/90.0.4430.78 Mobile/15E148 Safari/604.1",
+			Info{"Chrome", "iOS", DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 11; SM-G991B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/14.0 Chrome/87.0.4280.141 Mobile Safari/537.36",
+			Info{"Samsung Internet", "Android", DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 10; SM-T510) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.91 Safari/537.36",
+			Info{"Chrome", "Android", DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0",
+			Info{"Firefox", "Linux", DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1 Safari/605.1.15",
+			Info{"Safari", "macOS", DeviceDesktop},
+		},
+		{"", Info{Unknown, Unknown, Unknown}},
+	}
+	for _, c := range cases {
+		if actual := Parse(c.ua); actual != c.expected {
+			t.Errorf("User agent %q MUST be parsed as %+v, but %+v given", c.ua, c.expected, actual)
+		}
+	}
+}
+
+func Test_IsBot(t *testing.T) {
+	cases := map[string]bool{
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                                                            true,
+		"TelegramBot (like TwitterBot)":                                                                                         true,
+		"WhatsApp/2.21.9.15 A":                                                                                                  true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":                                             true,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                              true,
+		"Pinterestbot/1.0 (+http://www.pinterest.com/bot.html)":                                                                 true,
+		"Mozilla/5.0 (Windows NT 6.1; WOW64) SkypeUriPreview Preview/0.5":                                                       true,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Snap URL Preview Service; bot; snapchat; https://developers.snap.com/robots": true,
+		"curl/7.68.0": true,
+		"":            true,
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0":                    false,
+		"Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 Chrome/90.0.4430.91 Mobile Safari": false,
+		// in-app browsers of people
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [Pinterest/iOS]":                         false,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Snapchat/11.28.0.35 (like Safari/604.1)": false,
+		"Mozilla/5.0 (Linux; Android 11; SM-G991B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.91 Mobile Safari/537.36 Viber/15.4.0.8":              false,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Skype/8.71.0.49 Chrome/89.0.4389.128 Electron/12.0.5 Safari/537.36":   false,
+	}
+	for ua, expected := range cases {
+		if actual := IsBot(ua); actual != expected {
+			t.Errorf("User agent %q MUST be bot: %v, but %v given", ua, expected, actual)
+		}
+	}
+}
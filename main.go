@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	auth2 "koro.che/internal/auth"
+	link2 "koro.che/internal/domain/link"
+	"koro.che/internal/geoip"
+	linkfilter "koro.che/internal/interface/bloom/linkrepo"
+	linkcache "koro.che/internal/interface/cache/linkrepo"
 	"koro.che/internal/interface/httpapi"
 	"koro.che/internal/interface/postgres/accountrepo"
 	"koro.che/internal/interface/postgres/linkrepo"
+	"koro.che/internal/interface/prom"
 	"koro.che/internal/usecases/account"
 	"koro.che/internal/usecases/link"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	// stats are bucketed in user time zones, the runtime image has no tzdata
+	_ "time/tzdata"
 
 	_ "github.com/lib/pq"
 )
@@ -21,7 +34,25 @@ func main() {
 
 	privateKeyPath := flag.String("privateKey", "app.rsa", "file path")
 	publicKeyPath := flag.String("publicKey", "app.rsa.pub", "file path")
+	anonymousTTL := flag.Duration("anonymousTTL", link.DefaultAnonymousTTL, "lifetime of links created without an account")
+	maxTTL := flag.Duration("maxTTL", 0, "default limit of lifetime of links created by registered users, 0 means unlimited")
+	geoipPath := flag.String("geoip", "", "optional MaxMind format database (.mmdb) for click geography")
+	trustedProxies := flag.String("trustedProxies", "", "comma separated CIDRs of proxies trusted to set X-Forwarded-For")
+	cacheSize := flag.Int("cacheSize", 10000, "number of links cached for redirects, 0 disables the cache")
+	cacheTTL := flag.Duration("cacheTTL", time.Minute, "how long links changed by other instances may be served from the cache")
+	keyFilterRebuild := flag.Duration("keyFilterRebuild", 0, "how often the filter of existing keys drops deleted ones, 0 disables the filter; enable it only when a single instance serves the links")
+	keyGenerator := flag.String("keyGenerator", "random", "generator of link keys: random or sequence")
+	keySecret := flag.String("keySecret", os.Getenv("KOROCHE_KEY_SECRET"), "secret which shuffles keys of the sequence generator, required by it")
+	visitorSalt := flag.String("visitorSalt", os.Getenv("KOROCHE_VISITOR_SALT"), "secret salt of visitor hashes for unique visitor counting, required")
+	notActiveStatus := flag.Int("notActiveStatus", http.StatusNotFound, "status of redirects of scheduled links before their start")
+	notActivePage := flag.String("notActivePage", "", "optional HTML file shown to browsers on redirects of scheduled links before their start")
+	pausedStatus := flag.Int("pausedStatus", http.StatusServiceUnavailable, "status of redirects of paused links")
+	pausedPage := flag.String("pausedPage", "", "optional HTML file shown to browsers on redirects of paused links")
+	trashRetention := flag.Duration("trashRetention", link.DefaultTrashRetention, "how long deleted links may be restored before they are purged")
 	flag.Parse()
+	if *visitorSalt == "" {
+		panic("Visitor salt is required, otherwise addresses of visitors can be found by their hashes")
+	}
 
 	privateKeyBytes, err := ioutil.ReadFile(*privateKeyPath)
 	publicKeyBytes, err := ioutil.ReadFile(*publicKeyPath)
@@ -41,10 +72,63 @@ func main() {
 		AccountStorage: accountrepo.New(conn),
 		Auth:           a,
 	}
+	var linkStorage link2.Interface = linkrepo.New(conn)
+	if *keyFilterRebuild > 0 {
+		keyFilter, err := linkfilter.New(linkStorage, 0.01, *keyFilterRebuild)
+		if err != nil {
+			panic(fmt.Sprintf("Couldn't build key filter: %v", err))
+		}
+		defer keyFilter.Close()
+		linkStorage = keyFilter
+	}
+	if *cacheSize > 0 {
+		linkStorage = linkcache.New(linkStorage, *cacheSize, *cacheTTL, 5*time.Second)
+	}
+	clickRecorder := link.NewClickRecorder(linkStorage, 10000, 500, time.Second)
+	counters := link.NewCounterBuffer(linkStorage, 1000, time.Second)
+	prom.WatchCounterBacklog(counters.Pending)
+	trashPurger := link.NewTrashPurger(linkStorage, *trashRetention, time.Hour)
+	defer trashPurger.Close()
 	linkUseCases := link.LinkUseCases{
-		LinkStorage: linkrepo.New(conn),
+		LinkStorage:      linkStorage,
+		AnonymousTTL:     *anonymousTTL,
+		MaxTTL:           *maxTTL,
+		Clicks:           clickRecorder,
+		Counters:         counters,
+		VisitorSalt:      []byte(*visitorSalt),
+		PasswordAttempts: link.NewAttemptLimiter(5, time.Minute),
+	}
+	switch *keyGenerator {
+	case "random":
+	case "sequence":
+		if *keySecret == "" {
+			panic("Key secret is required by the sequence key generator, otherwise the keys are guessable")
+		}
+		linkUseCases.KeyGenerator = link.NewSequenceKeyGenerator(linkStorage, []byte(*keySecret))
+	default:
+		panic(fmt.Sprintf("Unknown key generator %q", *keyGenerator))
+	}
+	if *geoipPath != "" {
+		geo, err := geoip.Open(*geoipPath)
+		if err != nil {
+			panic(fmt.Sprintf("Couldn't open GeoIP database: %v", err))
+		}
+		defer geo.Close()
+		linkUseCases.Locator = geo
 	}
 	service := httpapi.NewApi(&accountUseCases, &linkUseCases)
+	service.TrustedProxies, err = parseCIDRs(*trustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	service.NotActive, err = loadStatusPage(*notActiveStatus, *notActivePage)
+	if err != nil {
+		panic(err)
+	}
+	service.Paused, err = loadStatusPage(*pausedStatus, *pausedPage)
+	if err != nil {
+		panic(err)
+	}
 
 	server := http.Server{
 		Addr:         ":8080",
@@ -52,8 +136,54 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 		Handler:      service.Router(),
 	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Printf("Couldn't shutdown server gracefully: %v\n", err)
+		}
+		// no more redirects, save clicks and counters which are still in buffer
+		clickRecorder.Close()
+		counters.Close()
+	}()
+
 	err = server.ListenAndServe()
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
+	<-shutdownDone
+}
+
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// loadStatusPage reads the optional HTML page answered with the status.
+func loadStatusPage(status int, path string) (httpapi.StatusPage, error) {
+	page := httpapi.StatusPage{Status: status}
+	if path == "" {
+		return page, nil
+	}
+	var err error
+	if page.Page, err = ioutil.ReadFile(path); err != nil {
+		return httpapi.StatusPage{}, fmt.Errorf("couldn't read page %q: %v", path, err)
+	}
+	return page, nil
 }